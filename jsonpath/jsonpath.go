@@ -0,0 +1,62 @@
+// Package jsonpath extracts numeric values from JSON documents using dotted
+// paths such as "sensors.0.value".
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the value at the path; array elements are selected by index.
+func Lookup(v any, path string) (any, error) {
+	for _, k := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]any:
+			c, ok := t[k]
+			if !ok {
+				return nil, fmt.Errorf("key \"%s\" not found", k)
+			}
+			v = c
+		case []any:
+			i, err := strconv.Atoi(k)
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 || i >= len(t) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			v = t[i]
+		default:
+			return nil, fmt.Errorf("cannot look up \"%s\" in a scalar", k)
+		}
+	}
+	return v, nil
+}
+
+// Extract parses the document and converts the value at the path into a
+// float. Booleans become 1 or 0 and strings are passed to convert.
+func Extract(doc []byte, path string, convert func(string) (float64, error)) (float64, error) {
+	var d any
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return 0, err
+	}
+	v, err := Lookup(d, path)
+	if err != nil {
+		return 0, err
+	}
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case bool:
+		if t {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return convert(t)
+	default:
+		return 0, fmt.Errorf("unexpected value at \"%s\"", path)
+	}
+}
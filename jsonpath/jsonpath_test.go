@@ -0,0 +1,33 @@
+package jsonpath
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	doc := []byte(`{"a": {"b": [1, true, "2.5"]}, "c": null}`)
+	for _, v := range []struct {
+		path  string
+		value float64
+	}{
+		{"a.b.0", 1},
+		{"a.b.1", 1},
+		{"a.b.2", 2.5},
+	} {
+		r, err := Extract(doc, v.path, func(s string) (float64, error) {
+			return strconv.ParseFloat(s, 64)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != v.value {
+			t.Fatalf("%s: %f != %f", v.path, r, v.value)
+		}
+	}
+	for _, p := range []string{"a.x", "a.b.3", "a.b.0.x", "c"} {
+		if _, err := Extract(doc, p, nil); err == nil {
+			t.Fatalf("%s was accepted", p)
+		}
+	}
+}
@@ -78,17 +78,30 @@ func (m *Manager) getPlugin(name string, node *yaml.Node) (plugin.Plugin, error)
 	return p, nil
 }
 
+// writeOutputs writes the value to each of the outputs in order; once an
+// output fails, any dependent outputs after it are skipped so that they
+// don't report a value that wasn't applied
+func writeOutputs(outputs []*managerOutputPluginAndData, v float64) {
+	failed := false
+	for _, o := range outputs {
+		if d, ok := o.Plugin.(plugin.DependentPlugin); ok && failed && d.Dependent(o.Data) {
+			log.Warn().Msgf("skipping %s after an earlier output failed", o.Name)
+			continue
+		}
+		if err := o.Plugin.Write(o.Data, v); err != nil {
+			log.Error().Msg(err.Error())
+			failed = true
+		}
+	}
+}
+
 func (m *Manager) doTask(t *managerTask) error {
 	v, err := t.Input.Plugin.Read(t.Input.Data)
 	if err != nil {
 		return err
 	}
 	log.Debug().Msgf("read %f from %s", v, t.Input.Name)
-	for _, o := range t.Outputs {
-		if err := o.Plugin.Write(o.Data, v); err != nil {
-			log.Error().Msg(err.Error())
-		}
-	}
+	writeOutputs(t.Outputs, v)
 	return nil
 }
 
@@ -257,11 +270,7 @@ func New(filename string) (*Manager, error) {
 					}
 				}
 				log.Debug().Msgf("triggered %f from %s", v, name)
-				for _, a := range actions {
-					if err := a.Plugin.Write(a.Data, v); err != nil {
-						log.Error().Msg(err.Error())
-					}
-				}
+				writeOutputs(actions, v)
 			}
 		}(t.Plugin, triggerData)
 	}
@@ -271,6 +280,13 @@ func New(filename string) (*Manager, error) {
 		return nil, errors.New("no tasks were created; aborting")
 	}
 
+	// Let plugins know that initialization is complete
+	for _, p := range m.plugins {
+		if r, ok := p.(plugin.ReadyPlugin); ok {
+			r.Ready()
+		}
+	}
+
 	// Start the goroutine for processing the inputs
 	go m.run()
 
@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testOutput is an output plugin whose outputs record the values written to
+// them and can be made to fail or to depend on the outputs before them
+type testOutput struct{}
+
+type testOutputData struct {
+	fail      bool
+	dependent bool
+	values    []float64
+}
+
+func (o *testOutput) WriteInit(*yaml.Node) (any, error) { return &testOutputData{}, nil }
+
+func (o *testOutput) Write(data any, v float64) error {
+	d := data.(*testOutputData)
+	if d.fail {
+		return errors.New("write failed")
+	}
+	d.values = append(d.values, v)
+	return nil
+}
+
+func (o *testOutput) WriteClose(any) {}
+
+func (o *testOutput) Dependent(data any) bool {
+	return data.(*testOutputData).dependent
+}
+
+func (o *testOutput) Close() {}
+
+func TestWriteOutputs(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		outputs []*testOutputData
+		written []bool
+	}{
+		{
+			name: "success",
+			outputs: []*testOutputData{
+				{},
+				{dependent: true},
+			},
+			written: []bool{true, true},
+		},
+		{
+			name: "failed write",
+			outputs: []*testOutputData{
+				{fail: true},
+				{},
+				{dependent: true},
+			},
+			written: []bool{false, true, false},
+		},
+		{
+			name: "failure after dependent output",
+			outputs: []*testOutputData{
+				{dependent: true},
+				{fail: true},
+			},
+			written: []bool{true, false},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			var (
+				p       = &testOutput{}
+				outputs = []*managerOutputPluginAndData{}
+			)
+			for _, d := range v.outputs {
+				outputs = append(outputs, &managerOutputPluginAndData{
+					Name:   "test",
+					Plugin: p,
+					Data:   d,
+				})
+			}
+			writeOutputs(outputs, 1)
+			for i, d := range v.outputs {
+				if written := len(d.values) != 0; written != v.written[i] {
+					t.Fatalf("output %d: %t != %t", i, written, v.written[i])
+				}
+			}
+		})
+	}
+}
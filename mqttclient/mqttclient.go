@@ -0,0 +1,192 @@
+package mqttclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	schemeTCP = "tcp"
+	schemeSSL = "ssl"
+	schemeWS  = "ws"
+	schemeWSS = "wss"
+)
+
+// Payloads published to the status topic.
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// TLSParams configures TLS for the connection to the broker.
+type TLSParams struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Params contains the connection settings shared by all plugins that connect
+// to an MQTT broker. It is intended to be inlined in plugin parameters.
+type Params struct {
+	Addr                 string        `yaml:"addr"`
+	Scheme               string        `yaml:"scheme"`
+	Username             string        `yaml:"username"`
+	Password             string        `yaml:"password"`
+	ClientID             string        `yaml:"client_id"`
+	KeepAlive            time.Duration `yaml:"keepalive"`
+	CleanSession         bool          `yaml:"clean_session"`
+	ConnectRetry         bool          `yaml:"connect_retry"`
+	ConnectRetryInterval time.Duration `yaml:"connect_retry_interval"`
+	StatusTopic          string        `yaml:"status_topic"`
+	TLS                  *TLSParams    `yaml:"tls"`
+}
+
+// DefaultParams provides sensible defaults that are overridden by values in
+// the config file.
+var DefaultParams = Params{
+	KeepAlive:            30 * time.Second,
+	CleanSession:         true,
+	ConnectRetryInterval: 30 * time.Second,
+}
+
+func (p *Params) brokerURL() (string, error) {
+	if p.Addr == "" {
+		return "", errors.New("MQTT broker address must be specified")
+	}
+	if strings.Contains(p.Addr, "://") {
+		return p.Addr, nil
+	}
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = schemeTCP
+		if p.TLS != nil {
+			scheme = schemeSSL
+		}
+	}
+	switch scheme {
+	case schemeTCP, schemeSSL, schemeWS, schemeWSS:
+	default:
+		return "", fmt.Errorf("invalid MQTT scheme \"%s\"", scheme)
+	}
+	return fmt.Sprintf("%s://%s", scheme, p.Addr), nil
+}
+
+func (t *TLSParams) config() (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		b, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// ClientOptions builds the options for a new client. If no client ID was
+// specified, defaultClientID is used instead.
+func (p *Params) ClientOptions(defaultClientID string) (*mqtt.ClientOptions, error) {
+	u, err := p.brokerURL()
+	if err != nil {
+		return nil, err
+	}
+	clientID := p.ClientID
+	if clientID == "" {
+		clientID = defaultClientID
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(u).
+		SetClientID(clientID).
+		SetResumeSubs(true).
+		SetPassword(p.Password).
+		SetUsername(p.Username).
+		SetKeepAlive(p.KeepAlive).
+		SetCleanSession(p.CleanSession).
+		SetConnectRetry(p.ConnectRetry).
+		SetConnectRetryInterval(p.ConnectRetryInterval)
+	if p.TLS != nil {
+		c, err := p.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(c)
+	}
+	return opts, nil
+}
+
+// HostnameClientID returns a client ID derived from the hostname of the
+// machine, which avoids collisions when multiple devices share a broker.
+func HostnameClientID() (string, error) {
+	h, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sensorpi_%s", h), nil
+}
+
+// PublishStatus publishes the provided status to the topic as a retained
+// message.
+func PublishStatus(c mqtt.Client, topic, status string) error {
+	if t := c.Publish(topic, 1, true, status); t.Wait() && t.Error() != nil {
+		return t.Error()
+	}
+	return nil
+}
+
+// SetAvailability configures a Last Will that marks the topic as offline if
+// the connection is lost and publishes "online" every time the client
+// (re)connects. If onConnect is not nil, it is invoked afterwards.
+func SetAvailability(opts *mqtt.ClientOptions, topic string, onConnect mqtt.OnConnectHandler) {
+	opts.SetWill(topic, StatusOffline, 1, true)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		if err := PublishStatus(c, topic, StatusOnline); err != nil {
+			log.Warn().Msgf("mqtt: %s", err.Error())
+		}
+		if onConnect != nil {
+			onConnect(c)
+		}
+	})
+}
+
+// Disconnect marks the topic as offline and then disconnects from the
+// broker. This is necessary since the Last Will is not sent for a clean
+// disconnect.
+func Disconnect(c mqtt.Client, topic string) {
+	if err := PublishStatus(c, topic, StatusOffline); err != nil {
+		log.Warn().Msgf("mqtt: %s", err.Error())
+	}
+	c.Disconnect(1000)
+}
+
+// Connect creates a new client with the provided options and connects to the
+// broker. If connection retries are enabled, this blocks until the broker
+// becomes available.
+func Connect(opts *mqtt.ClientOptions) (mqtt.Client, error) {
+	c := mqtt.NewClient(opts)
+	if t := c.Connect(); t.Wait() && t.Error() != nil {
+		return nil, t.Error()
+	}
+	return c, nil
+}
@@ -0,0 +1,28 @@
+package mqttclient
+
+import (
+	"testing"
+)
+
+func TestBrokerURL(t *testing.T) {
+	for _, v := range []struct {
+		params Params
+		url    string
+	}{
+		{Params{Addr: "localhost:1883"}, "tcp://localhost:1883"},
+		{Params{Addr: "localhost:8883", TLS: &TLSParams{}}, "ssl://localhost:8883"},
+		{Params{Addr: "localhost:8080", Scheme: "ws"}, "ws://localhost:8080"},
+		{Params{Addr: "wss://localhost:443/mqtt"}, "wss://localhost:443/mqtt"},
+	} {
+		u, err := v.params.brokerURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != v.url {
+			t.Fatalf("%s != %s", u, v.url)
+		}
+	}
+	if _, err := (&Params{Addr: "localhost", Scheme: "http"}).brokerURL(); err == nil {
+		t.Fatal("invalid scheme was accepted")
+	}
+}
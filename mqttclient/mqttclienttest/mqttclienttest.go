@@ -0,0 +1,126 @@
+// Package mqttclienttest provides a fake MQTT client for testing plugins
+// without a broker.
+package mqttclienttest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Token is a token for an operation that has already completed successfully.
+type Token struct{}
+
+func (Token) Wait() bool                     { return true }
+func (Token) WaitTimeout(time.Duration) bool { return true }
+func (Token) Done() <-chan struct{}          { c := make(chan struct{}); close(c); return c }
+func (Token) Error() error                   { return nil }
+
+// Message is a message delivered by Deliver.
+type Message struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m *Message) Topic() string   { return m.topic }
+func (m *Message) Payload() []byte { return m.payload }
+
+// Client records subscriptions the way paho does, with one handler per
+// topic, and the last message published to each topic. Methods that aren't
+// implemented panic.
+type Client struct {
+	mqtt.Client
+	mutex        sync.Mutex
+	routes       map[string]mqtt.MessageHandler
+	published    map[string]string
+	subscribes   int
+	unsubscribes int
+}
+
+// NewClient creates a new fake client.
+func NewClient() *Client {
+	return &Client{
+		routes:    make(map[string]mqtt.MessageHandler),
+		published: make(map[string]string),
+	}
+}
+
+func (c *Client) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch p := payload.(type) {
+	case []byte:
+		c.published[topic] = string(p)
+	default:
+		c.published[topic] = fmt.Sprint(p)
+	}
+	return Token{}
+}
+
+func (c *Client) Subscribe(topic string, qos byte, h mqtt.MessageHandler) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.routes[topic] = h
+	c.subscribes++
+	return Token{}
+}
+
+func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, t := range topics {
+		delete(c.routes, t)
+	}
+	c.unsubscribes++
+	return Token{}
+}
+
+// Deliver passes a message to the handler subscribed to the topic, if any.
+func (c *Client) Deliver(topic, payload string) {
+	c.mutex.Lock()
+	h, ok := c.routes[topic]
+	c.mutex.Unlock()
+	if ok {
+		h(c, &Message{topic: topic, payload: []byte(payload)})
+	}
+}
+
+// Reconnect drops every subscription, as a reconnect with a clean session
+// does.
+func (c *Client) Reconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.routes = make(map[string]mqtt.MessageHandler)
+}
+
+// Published returns the last payload published to the topic.
+func (c *Client) Published(topic string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.published[topic]
+}
+
+// Subscribed returns true if there is a handler for the topic.
+func (c *Client) Subscribed(topic string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.routes[topic]
+	return ok
+}
+
+// Subscribes returns the number of calls to Subscribe.
+func (c *Client) Subscribes() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.subscribes
+}
+
+// Unsubscribes returns the number of calls to Unsubscribe.
+func (c *Client) Unsubscribes() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.unsubscribes
+}
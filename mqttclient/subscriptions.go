@@ -0,0 +1,111 @@
+package mqttclient
+
+import (
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+// Handler receives the payload of each message on a topic. Like paho's
+// message handlers, it must not block.
+type Handler func(payload []byte)
+
+// Subscriptions shares a single subscription per topic between any number of
+// handlers. Paho keeps one route per topic, so subscribing to the same topic
+// twice replaces the first handler and unsubscribing removes it for everyone.
+type Subscriptions struct {
+	mutex  sync.Mutex
+	topics map[string]*subscription
+	nextID int
+}
+
+type subscription struct {
+	qos      byte
+	handlers map[int]Handler
+}
+
+// NewSubscriptions creates an empty set of subscriptions.
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		topics: make(map[string]*subscription),
+	}
+}
+
+// dispatch passes a message on to every handler for its topic
+func (s *Subscriptions) dispatch(topic string) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		s.mutex.Lock()
+		handlers := []Handler{}
+		if sub, ok := s.topics[topic]; ok {
+			for _, h := range sub.handlers {
+				handlers = append(handlers, h)
+			}
+		}
+		s.mutex.Unlock()
+		for _, h := range handlers {
+			h(msg.Payload())
+		}
+	}
+}
+
+// Subscribe adds a handler for the topic, subscribing if it is the first
+// one (or if it needs a higher QoS). The returned function removes the
+// handler again, unsubscribing once no handlers remain.
+func (s *Subscriptions) Subscribe(c mqtt.Client, topic string, qos byte, h Handler) (func(), error) {
+	s.mutex.Lock()
+	sub, ok := s.topics[topic]
+	if !ok {
+		sub = &subscription{
+			qos:      qos,
+			handlers: make(map[int]Handler),
+		}
+		s.topics[topic] = sub
+	}
+	id := s.nextID
+	s.nextID++
+	sub.handlers[id] = h
+	subscribe := !ok || qos > sub.qos
+	if qos > sub.qos {
+		sub.qos = qos
+	}
+	s.mutex.Unlock()
+	unsubscribe := func() {
+		s.mutex.Lock()
+		delete(sub.handlers, id)
+		last := len(sub.handlers) == 0 && s.topics[topic] == sub
+		if last {
+			delete(s.topics, topic)
+		}
+		s.mutex.Unlock()
+		if last {
+			if t := c.Unsubscribe(topic); t.Wait() && t.Error() != nil {
+				log.Warn().Msgf("mqtt: %s", t.Error())
+			}
+		}
+	}
+	if subscribe {
+		if t := c.Subscribe(topic, qos, s.dispatch(topic)); t.Wait() && t.Error() != nil {
+			unsubscribe()
+			return nil, t.Error()
+		}
+	}
+	return unsubscribe, nil
+}
+
+// Restore subscribes to every topic again. Subscriptions do not survive a
+// reconnect with a clean session, so this should be called from the client's
+// OnConnect handler.
+func (s *Subscriptions) Restore(c mqtt.Client) {
+	s.mutex.Lock()
+	topics := make(map[string]byte)
+	for topic, sub := range s.topics {
+		topics[topic] = sub.qos
+	}
+	s.mutex.Unlock()
+	for topic, qos := range topics {
+		if t := c.Subscribe(topic, qos, s.dispatch(topic)); t.Wait() && t.Error() != nil {
+			log.Warn().Msgf("mqtt: %s", t.Error())
+		}
+	}
+}
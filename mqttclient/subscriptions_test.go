@@ -0,0 +1,42 @@
+package mqttclient
+
+import (
+	"testing"
+
+	"github.com/nathan-osman/sensorpi/mqttclient/mqttclienttest"
+)
+
+func TestSubscriptions(t *testing.T) {
+	var (
+		c    = mqttclienttest.NewClient()
+		s    = NewSubscriptions()
+		a, b []string
+	)
+	unsubA, err := s.Subscribe(c, "t", 0, func(p []byte) { a = append(a, string(p)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubB, err := s.Subscribe(c, "t", 0, func(p []byte) { b = append(b, string(p)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subscribes() != 1 {
+		t.Fatalf("%d subscriptions, expected 1", c.Subscribes())
+	}
+	c.Deliver("t", "1")
+	// Simulate a reconnect with a clean session
+	c.Reconnect()
+	s.Restore(c)
+	unsubA()
+	if c.Unsubscribes() != 0 {
+		t.Fatal("unsubscribed while a handler remained")
+	}
+	c.Deliver("t", "2")
+	unsubB()
+	if c.Unsubscribes() != 1 {
+		t.Fatal("did not unsubscribe after the last handler was removed")
+	}
+	if len(a) != 1 || len(b) != 2 {
+		t.Fatalf("unexpected messages %v, %v", a, b)
+	}
+}
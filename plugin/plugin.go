@@ -53,3 +53,21 @@ type TriggerPlugin interface {
 	// WatchClose performs any cleanup from WatchInit.
 	WatchClose(any)
 }
+
+// ReadyPlugin may optionally be implemented by plugins that need to be
+// notified once all inputs, outputs, and triggers have been initialized.
+type ReadyPlugin interface {
+
+	// Ready is invoked after initialization is complete.
+	Ready()
+}
+
+// DependentPlugin may optionally be implemented by output plugins with
+// outputs that report the result of the outputs listed before them, such as
+// the state of a device that they control.
+type DependentPlugin interface {
+
+	// Dependent returns true if the output should be skipped when writing
+	// to an earlier output failed.
+	Dependent(any) bool
+}
@@ -14,3 +14,13 @@ func IsTriggerPlugin(v any) bool {
 	_, ok := v.(TriggerPlugin)
 	return ok
 }
+
+func IsReadyPlugin(v any) bool {
+	_, ok := v.(ReadyPlugin)
+	return ok
+}
+
+func IsDependentPlugin(v any) bool {
+	_, ok := v.(DependentPlugin)
+	return ok
+}
@@ -2,6 +2,10 @@ package bme280
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
 	"gopkg.in/yaml.v3"
@@ -16,69 +20,294 @@ const (
 	quantityTemperature = "temperature"
 	quantityHumidity    = "humidity"
 	quantityPressure    = "pressure"
+	quantityGas         = "gas"
+
+	defaultBus               = "1"
+	defaultAddress           = 0x76
+	defaultHeaterTemperature = 320
+	defaultHeaterDuration    = 150 * time.Millisecond
 )
 
-// BME280 provides access to a BME280 sensor.
+// BME280 provides access to BME280, BMP280 and BME680 sensors. Inputs that
+// refer to the same bus and address share a single device.
 type BME280 struct {
-	bus i2c.BusCloser
+	mutex   sync.Mutex
+	buses   map[string]i2c.BusCloser
+	devices map[string]*device
+}
+
+// reading is a single measurement; pressure is in hPa and gas resistance in
+// ohms
+type reading struct {
+	temperature   float64
+	humidity      float64
+	pressure      float64
+	gasResistance float64
+	hasHumidity   bool
+	hasGas        bool
+}
+
+// sensor is implemented by each of the supported chips
+type sensor interface {
+	Sense(*reading) error
+	Halt() error
+}
+
+type device struct {
+	key    string
+	sensor sensor
+	refs   int
+}
+
+// deviceOpts are applied when the device is first opened; inputs sharing a
+// device after that use the same options
+type deviceOpts struct {
+	temperature       bmxx80.Oversampling
+	pressure          bmxx80.Oversampling
+	humidity          bmxx80.Oversampling
+	filter            int // coefficient, encoded differently by each chip
+	standby           time.Duration
+	heaterTemperature float64
+	heaterDuration    time.Duration
+}
+
+type oversamplingParams struct {
+	Temperature int `yaml:"temperature"`
+	Pressure    int `yaml:"pressure"`
+	Humidity    int `yaml:"humidity"`
+}
+
+type heaterParams struct {
+	Temperature *float64      `yaml:"temperature"`
+	Duration    time.Duration `yaml:"duration"`
 }
 
 type inputParams struct {
-	Address  uint16 `yaml:"address"`
-	Quantity string `yaml:"quantity"`
+	Bus          string             `yaml:"bus"`
+	Address      uint16             `yaml:"address"`
+	Quantity     string             `yaml:"quantity"`
+	Oversampling oversamplingParams `yaml:"oversampling"`
+	Filter       int                `yaml:"filter"`
+	Standby      time.Duration      `yaml:"standby"`
+	Heater       heaterParams       `yaml:"heater"`
 }
 
 type inputData struct {
-	dev      *bmxx80.Dev
+	device   *device
 	quantity string
 }
 
+// bmxSensor adapts the bmxx80 driver to the sensor interface
+type bmxSensor struct {
+	dev         *bmxx80.Dev
+	hasHumidity bool
+}
+
 func init() {
 	plugin.Register("bme280", func(node *yaml.Node) (plugin.Plugin, error) {
 		_, err := host.Init()
 		if err != nil {
 			return nil, err
 		}
-		b, err := i2creg.Open("1")
-		if err != nil {
-			return nil, err
-		}
 		return &BME280{
-			bus: b,
+			buses:   make(map[string]i2c.BusCloser),
+			devices: make(map[string]*device),
 		}, nil
 	})
 }
 
+func parseOversampling(v int) (bmxx80.Oversampling, error) {
+	switch v {
+	case 0:
+		return bmxx80.O4x, nil
+	case 1:
+		return bmxx80.O1x, nil
+	case 2:
+		return bmxx80.O2x, nil
+	case 4:
+		return bmxx80.O4x, nil
+	case 8:
+		return bmxx80.O8x, nil
+	case 16:
+		return bmxx80.O16x, nil
+	default:
+		return 0, fmt.Errorf("invalid oversampling %d", v)
+	}
+}
+
+// parseFilter converts an IIR filter coefficient to the BMP280/BME280
+// encoding; the BME680 uses different coefficients (see bme680Filter)
+func parseFilter(v int) (bmxx80.Filter, error) {
+	switch v {
+	case 0:
+		return bmxx80.NoFilter, nil
+	case 2:
+		return bmxx80.F2, nil
+	case 4:
+		return bmxx80.F4, nil
+	case 8:
+		return bmxx80.F8, nil
+	case 16:
+		return bmxx80.F16, nil
+	default:
+		return 0, fmt.Errorf("invalid filter %d", v)
+	}
+}
+
+func (p *inputParams) deviceOpts() (*deviceOpts, error) {
+	o := &deviceOpts{
+		filter:            p.Filter,
+		standby:           p.Standby,
+		heaterTemperature: defaultHeaterTemperature,
+		heaterDuration:    defaultHeaterDuration,
+	}
+	var err error
+	if o.temperature, err = parseOversampling(p.Oversampling.Temperature); err != nil {
+		return nil, err
+	}
+	if o.pressure, err = parseOversampling(p.Oversampling.Pressure); err != nil {
+		return nil, err
+	}
+	if o.humidity, err = parseOversampling(p.Oversampling.Humidity); err != nil {
+		return nil, err
+	}
+	if p.Heater.Temperature != nil {
+		o.heaterTemperature = *p.Heater.Temperature
+	}
+	if p.Heater.Duration != 0 {
+		o.heaterDuration = p.Heater.Duration
+	}
+	return o, nil
+}
+
+func (s *bmxSensor) Sense(r *reading) error {
+	var env physic.Env
+	if err := s.dev.Sense(&env); err != nil {
+		return err
+	}
+	r.temperature = env.Temperature.Celsius()
+	r.humidity = float64(env.Humidity) / 1e5
+	r.pressure = float64(env.Pressure) / 1e11
+	r.hasHumidity = s.hasHumidity
+	return nil
+}
+
+func (s *bmxSensor) Halt() error {
+	return s.dev.Halt()
+}
+
+// openBus returns the named bus, opening it if necessary; the mutex must be
+// held
+func (b *BME280) openBus(name string) (i2c.Bus, error) {
+	if bus, ok := b.buses[name]; ok {
+		return bus, nil
+	}
+	bus, err := i2creg.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	b.buses[name] = bus
+	return bus, nil
+}
+
+// openSensor detects the chip at the address and creates the matching driver
+func openSensor(bus i2c.Bus, address uint16, opts *deviceOpts) (sensor, error) {
+	var (
+		dev = &i2c.Dev{Bus: bus, Addr: address}
+		id  = make([]byte, 1)
+	)
+	if err := dev.Tx([]byte{bme680RegChipID}, id); err != nil {
+		return nil, err
+	}
+	if id[0] == bme680ChipID {
+		return newBME680(dev, opts)
+	}
+	filter, err := parseFilter(opts.filter)
+	if err != nil {
+		return nil, err
+	}
+	d, err := bmxx80.NewI2C(bus, address, &bmxx80.Opts{
+		Temperature: opts.temperature,
+		Pressure:    opts.pressure,
+		Humidity:    opts.humidity,
+		Filter:      filter,
+		Standby:     opts.standby,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bmxSensor{
+		dev:         d,
+		hasHumidity: strings.HasPrefix(d.String(), "BME280"),
+	}, nil
+}
+
 func (b *BME280) ReadInit(node *yaml.Node) (any, error) {
-	params := &inputParams{}
+	params := &inputParams{
+		Bus:     defaultBus,
+		Address: defaultAddress,
+	}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	d, err := bmxx80.NewI2C(b.bus, 0x76, &bmxx80.DefaultOpts)
+	switch params.Quantity {
+	case quantityTemperature, quantityHumidity, quantityPressure, quantityGas:
+	default:
+		return nil, errors.New("invalid quantity specified")
+	}
+	opts, err := params.deviceOpts()
 	if err != nil {
 		return nil, err
 	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	key := fmt.Sprintf("%s:%#x", params.Bus, params.Address)
+	d, ok := b.devices[key]
+	if !ok {
+		bus, err := b.openBus(params.Bus)
+		if err != nil {
+			return nil, err
+		}
+		s, err := openSensor(bus, params.Address, opts)
+		if err != nil {
+			return nil, err
+		}
+		d = &device{
+			key:    key,
+			sensor: s,
+		}
+		b.devices[key] = d
+	}
+	d.refs++
 	return &inputData{
-		dev:      d,
+		device:   d,
 		quantity: params.Quantity,
 	}, nil
 }
 
 func (b *BME280) Read(data any) (float64, error) {
 	var (
-		d   = data.(*inputData)
-		env physic.Env
+		d = data.(*inputData)
+		r reading
 	)
-	if err := d.dev.Sense(&env); err != nil {
+	if err := d.device.sensor.Sense(&r); err != nil {
 		return 0, err
 	}
 	switch d.quantity {
 	case quantityTemperature:
-		return env.Temperature.Celsius(), nil
+		return r.temperature, nil
 	case quantityHumidity:
-		return float64(env.Humidity) / 1e5, nil
+		if !r.hasHumidity {
+			return 0, errors.New("sensor does not measure humidity")
+		}
+		return r.humidity, nil
 	case quantityPressure:
-		return float64(env.Pressure) / 1e11, nil
+		return r.pressure, nil
+	case quantityGas:
+		if !r.hasGas {
+			return 0, errors.New("gas resistance is not available")
+		}
+		return r.gasResistance, nil
 	default:
 		return 0, errors.New("invalid quantity specified")
 	}
@@ -86,10 +315,18 @@ func (b *BME280) Read(data any) (float64, error) {
 
 func (b *BME280) ReadClose(data any) {
 	d := data.(*inputData)
-	d.dev.Halt()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	d.device.refs--
+	if d.device.refs == 0 {
+		d.device.sensor.Halt()
+		delete(b.devices, d.device.key)
+	}
 }
 
 // Close shuts down the plugin.
 func (b *BME280) Close() {
-	b.bus.Close()
+	for _, bus := range b.buses {
+		bus.Close()
+	}
 }
@@ -0,0 +1,320 @@
+package bme280
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+const (
+	bme680ChipID = 0x61
+
+	bme680RegChipID   = 0xd0
+	bme680RegReset    = 0xe0
+	bme680RegCoeff1   = 0x8a
+	bme680RegCoeff2   = 0xe1
+	bme680RegCoeff3   = 0x00
+	bme680RegCtrlGas1 = 0x71
+	bme680RegCtrlHum  = 0x72
+	bme680RegCtrlMeas = 0x74
+	bme680RegConfig   = 0x75
+	bme680RegGasWait0 = 0x64
+	bme680RegResHeat0 = 0x5a
+	bme680RegData     = 0x1d
+
+	bme680ResetCommand = 0xb6
+	bme680ModeSleep    = 0x00
+	bme680ModeForced   = 0x01
+	bme680RunGas       = 0x10
+
+	bme680StatusNewData = 0x80
+	bme680GasValid      = 0x20
+	bme680HeatStable    = 0x10
+
+	bme680PollInterval = 10 * time.Millisecond
+	bme680PollTimeout  = time.Second
+)
+
+// Gas resistance range correction factors from the datasheet
+var (
+	bme680GasRangeK1 = [16]float64{0, 0, 0, 0, 0, -1, 0, -0.8, 0, 0, -0.2, -0.5, 0, -1, 0, 0}
+	bme680GasRangeK2 = [16]float64{0, 0, 0, 0, 0.1, 0.7, 0, -0.8, -0.1, 0, 0, 0, 0, 0, 0, 0}
+)
+
+// bme680Calibration holds the factory calibration coefficients stored in the
+// sensor's non-volatile memory
+type bme680Calibration struct {
+	t1, t2, t3                              float64
+	p1, p2, p3, p4, p5, p6, p7, p8, p9, p10 float64
+	h1, h2, h3, h4, h5, h6, h7              float64
+	gh1, gh2, gh3                           float64
+	resHeatRange, resHeatVal, rangeSwErr    float64
+}
+
+// bme680 is a minimal driver for the BME680, which the bmxx80 package does not
+// support; it takes measurements in forced mode with a single heater profile
+type bme680 struct {
+	mutex       sync.Mutex
+	dev         *i2c.Dev
+	opts        *deviceOpts
+	cal         bme680Calibration
+	lastAmbient float64
+}
+
+func newBME680(dev *i2c.Dev, opts *deviceOpts) (*bme680, error) {
+	b := &bme680{
+		dev:         dev,
+		opts:        opts,
+		lastAmbient: 25,
+	}
+	if err := b.write(bme680RegReset, bme680ResetCommand); err != nil {
+		return nil, err
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := b.readCalibration(); err != nil {
+		return nil, err
+	}
+	if err := b.write(bme680RegCtrlHum, byte(opts.humidity)); err != nil {
+		return nil, err
+	}
+	filter, err := bme680Filter(opts.filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.write(bme680RegConfig, filter<<2); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bme680Filter converts an IIR filter coefficient to the value of the filter
+// field in the config register; the coefficients differ from the BME280's
+func bme680Filter(v int) (byte, error) {
+	for i, c := range []int{0, 1, 3, 7, 15, 31, 63, 127} {
+		if v == c {
+			return byte(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid filter %d for BME680", v)
+}
+
+func (b *bme680) read(reg byte, n int) ([]byte, error) {
+	r := make([]byte, n)
+	if err := b.dev.Tx([]byte{reg}, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *bme680) write(reg, v byte) error {
+	return b.dev.Tx([]byte{reg, v}, nil)
+}
+
+func (b *bme680) readCalibration() error {
+	c := []byte{}
+	for _, r := range []struct {
+		reg byte
+		n   int
+	}{
+		{bme680RegCoeff1, 23},
+		{bme680RegCoeff2, 14},
+		{bme680RegCoeff3, 5},
+	} {
+		v, err := b.read(r.reg, r.n)
+		if err != nil {
+			return err
+		}
+		c = append(c, v...)
+	}
+	var (
+		u16 = func(msb, lsb int) float64 { return float64(uint16(c[msb])<<8 | uint16(c[lsb])) }
+		s16 = func(msb, lsb int) float64 { return float64(int16(uint16(c[msb])<<8 | uint16(c[lsb]))) }
+		s8  = func(i int) float64 { return float64(int8(c[i])) }
+	)
+	b.cal = bme680Calibration{
+		t1:           u16(32, 31),
+		t2:           s16(1, 0),
+		t3:           s8(2),
+		p1:           u16(5, 4),
+		p2:           s16(7, 6),
+		p3:           s8(8),
+		p4:           s16(11, 10),
+		p5:           s16(13, 12),
+		p6:           s8(15),
+		p7:           s8(14),
+		p8:           s16(19, 18),
+		p9:           s16(21, 20),
+		p10:          float64(c[22]),
+		h1:           float64(uint16(c[25])<<4 | uint16(c[24]&0x0f)),
+		h2:           float64(uint16(c[23])<<4 | uint16(c[24]>>4)),
+		h3:           s8(26),
+		h4:           s8(27),
+		h5:           s8(28),
+		h6:           float64(c[29]),
+		h7:           s8(30),
+		gh1:          s8(35),
+		gh2:          s16(34, 33),
+		gh3:          s8(36),
+		resHeatRange: float64((c[39] & 0x30) >> 4),
+		resHeatVal:   s8(37),
+		rangeSwErr:   float64(int8(c[41]) >> 4),
+	}
+	return nil
+}
+
+// heaterResistance converts the target heater temperature into the value
+// written to the res_heat register
+func (b *bme680) heaterResistance(target float64) byte {
+	var (
+		c     = &b.cal
+		v1    = c.gh1/16 + 49
+		v2    = c.gh2/32768*0.0005 + 0.00235
+		v3    = c.gh3 / 1024
+		v4    = v1 * (1 + v2*math.Min(target, 400))
+		v5    = v4 + v3*b.lastAmbient
+		rHeat = 3.4 * (v5*(4/(4+c.resHeatRange))*(1/(1+c.resHeatVal*0.002)) - 25)
+	)
+	return byte(math.Max(0, math.Min(255, rHeat)))
+}
+
+// heaterDuration encodes the heater duration for the gas_wait register
+func heaterDuration(d time.Duration) byte {
+	ms := d.Milliseconds()
+	if ms >= 0xfc0 {
+		return 0xff
+	}
+	var factor int64
+	for ms > 0x3f {
+		ms /= 4
+		factor++
+	}
+	return byte(ms + factor*64)
+}
+
+// Sense triggers a forced mode measurement and waits for the result.
+func (b *bme680) Sense(r *reading) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	gas := b.opts.heaterTemperature > 0
+	if gas {
+		for _, v := range [][]byte{
+			{bme680RegResHeat0, b.heaterResistance(b.opts.heaterTemperature)},
+			{bme680RegGasWait0, heaterDuration(b.opts.heaterDuration)},
+			{bme680RegCtrlGas1, bme680RunGas},
+		} {
+			if err := b.write(v[0], v[1]); err != nil {
+				return err
+			}
+		}
+	} else if err := b.write(bme680RegCtrlGas1, 0); err != nil {
+		return err
+	}
+	if err := b.write(
+		bme680RegCtrlMeas,
+		byte(b.opts.temperature)<<5|byte(b.opts.pressure)<<2|bme680ModeForced,
+	); err != nil {
+		return err
+	}
+	var (
+		data     []byte
+		deadline = time.Now().Add(b.opts.heaterDuration + bme680PollTimeout)
+	)
+	for {
+		time.Sleep(bme680PollInterval)
+		v, err := b.read(bme680RegData, 15)
+		if err != nil {
+			return err
+		}
+		if v[0]&bme680StatusNewData != 0 {
+			data = v
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for BME680 measurement")
+		}
+	}
+	var (
+		pAdc   = float64(uint32(data[2])<<12 | uint32(data[3])<<4 | uint32(data[4])>>4)
+		tAdc   = float64(uint32(data[5])<<12 | uint32(data[6])<<4 | uint32(data[7])>>4)
+		hAdc   = float64(uint16(data[8])<<8 | uint16(data[9]))
+		gAdc   = float64(uint16(data[13])<<2 | uint16(data[14])>>6)
+		gRange = data[14] & 0x0f
+		tFine  = b.compensateTemperature(tAdc)
+	)
+	r.temperature = tFine / 5120
+	r.pressure = b.compensatePressure(pAdc, tFine) / 100
+	r.humidity = b.compensateHumidity(hAdc, r.temperature)
+	r.hasHumidity = true
+	b.lastAmbient = r.temperature
+	// The gas resistance is only meaningful once the heater has stabilised
+	if gas && data[14]&bme680GasValid != 0 && data[14]&bme680HeatStable != 0 {
+		r.gasResistance = b.compensateGas(gAdc, gRange)
+		r.hasGas = true
+	}
+	return nil
+}
+
+// compensateTemperature returns t_fine, from which the temperature and the
+// other compensations are derived
+func (b *bme680) compensateTemperature(adc float64) float64 {
+	var (
+		c  = &b.cal
+		v1 = (adc/16384 - c.t1/1024) * c.t2
+		v2 = (adc/131072 - c.t1/8192) * (adc/131072 - c.t1/8192) * (c.t3 * 16)
+	)
+	return v1 + v2
+}
+
+// compensatePressure returns the pressure in Pa
+func (b *bme680) compensatePressure(adc, tFine float64) float64 {
+	c := &b.cal
+	v1 := tFine/2 - 64000
+	v2 := v1 * v1 * (c.p6 / 131072)
+	v2 = v2 + v1*c.p5*2
+	v2 = v2/4 + c.p4*65536
+	v1 = (c.p3*v1*v1/16384 + c.p2*v1) / 524288
+	v1 = (1 + v1/32768) * c.p1
+	if v1 == 0 {
+		return 0
+	}
+	p := 1048576 - adc
+	p = (p - v2/4096) * 6250 / v1
+	v1 = c.p9 * p * p / 2147483648
+	v2 = p * (c.p8 / 32768)
+	v3 := math.Pow(p/256, 3) * (c.p10 / 131072)
+	return p + (v1+v2+v3+c.p7*128)/16
+}
+
+// compensateHumidity returns the relative humidity in percent
+func (b *bme680) compensateHumidity(adc, temperature float64) float64 {
+	var (
+		c  = &b.cal
+		v1 = adc - (c.h1*16 + c.h3/2*temperature)
+		v2 = v1 * (c.h2 / 262144 * (1 + c.h4/16384*temperature + c.h5/1048576*temperature*temperature))
+		v3 = c.h6 / 16384
+		v4 = c.h7 / 2097152
+		h  = v2 + (v3+v4*temperature)*v2*v2
+	)
+	return math.Max(0, math.Min(100, h))
+}
+
+// compensateGas returns the gas resistance in ohms
+func (b *bme680) compensateGas(adc float64, gasRange byte) float64 {
+	var (
+		v1 = 1340 + 5*b.cal.rangeSwErr
+		v2 = v1 * (1 + bme680GasRangeK1[gasRange]/100)
+		v3 = 1 + bme680GasRangeK2[gasRange]/100
+	)
+	return 1 / (v3 * 0.000000125 * float64(uint32(1)<<gasRange) * ((adc-512)/v2 + 1))
+}
+
+// Halt puts the sensor to sleep.
+func (b *bme680) Halt() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.write(bme680RegCtrlMeas, bme680ModeSleep)
+}
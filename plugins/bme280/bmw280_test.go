@@ -2,6 +2,7 @@ package bme280
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
 )
@@ -11,3 +12,48 @@ func TestPlugin(t *testing.T) {
 		t.Fatal("BME280 does not correctly implement InputPlugin")
 	}
 }
+
+func TestHeaterDuration(t *testing.T) {
+	for _, v := range []struct {
+		duration time.Duration
+		value    byte
+	}{
+		{50 * time.Millisecond, 0x32},
+		{150 * time.Millisecond, 0x65},
+		{5 * time.Second, 0xff},
+	} {
+		if r := heaterDuration(v.duration); r != v.value {
+			t.Fatalf("%s: %#x != %#x", v.duration, r, v.value)
+		}
+	}
+}
+
+func TestBME680Filter(t *testing.T) {
+	for _, v := range []struct {
+		filter int
+		value  byte
+		valid  bool
+	}{
+		{0, 0, true},
+		{1, 1, true},
+		{3, 2, true},
+		{15, 4, true},
+		{127, 7, true},
+		{2, 0, false},
+		{16, 0, false},
+	} {
+		r, err := bme680Filter(v.filter)
+		if !v.valid {
+			if err == nil {
+				t.Fatalf("%d was accepted", v.filter)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != v.value {
+			t.Fatalf("%d: %d != %d", v.filter, r, v.value)
+		}
+	}
+}
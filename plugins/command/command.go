@@ -1,19 +1,68 @@
 package command
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
 	"gopkg.in/yaml.v3"
 )
 
-// Command executes a command as an action.
+const (
+	defaultInputTimeout = 30 * time.Second
+
+	// processWaitDelay limits how long to wait for stdout and stderr to be
+	// closed once the process has exited or been killed, since a child it
+	// started may still hold them open
+	processWaitDelay = 5 * time.Second
+)
+
+// Command executes a command as an action or runs a command to read a value.
 type Command struct{}
 
+// commandParams describes the program to run and its environment
+type commandParams struct {
+	Name    string            `yaml:"name"`
+	Args    []string          `yaml:"arguments"`
+	Dir     string            `yaml:"dir"`
+	Env     map[string]string `yaml:"env"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+type inputParams struct {
+	commandParams  `yaml:",inline"`
+	parseParams    `yaml:",inline"`
+	IgnoreExitCode bool `yaml:"ignore_exit_code"`
+}
+
+type inputData struct {
+	params *inputParams
+	parser *parser
+}
+
 type outputParams struct {
-	Name string   `yaml:"name"`
-	Args []string `yaml:"arguments"`
+	commandParams `yaml:",inline"`
+	Stdin         string `yaml:"stdin"`
+}
+
+type outputData struct {
+	params *outputParams
+	args   []*template.Template
+	stdin  *template.Template
+}
+
+// templateData is made available to the argument and stdin templates
+type templateData struct {
+	Value     float64
+	Timestamp time.Time
 }
 
 func init() {
@@ -22,27 +71,161 @@ func init() {
 	})
 }
 
+// command creates the command with the arguments and extra environment
+// variables provided
+func (p *commandParams) command(ctx context.Context, args []string, env map[string]string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, p.Name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = processWaitDelay
+	cmd.Dir = p.Dir
+	cmd.Env = os.Environ()
+	for _, m := range []map[string]string{p.Env, env} {
+		for k, v := range m {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	return cmd
+}
+
+// run runs the command to completion and returns stdout; if the command
+// fails, stderr is used for the error where possible
+func run(cmd *exec.Cmd, ignoreExitCode bool) (string, error) {
+	var (
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+	)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		var e *exec.ExitError
+		if errors.As(err, &e) {
+			if ignoreExitCode && e.Exited() {
+				return stdout.String(), nil
+			}
+			if s := strings.TrimSpace(stderr.String()); len(s) != 0 {
+				return "", fmt.Errorf("%s: %s", err.Error(), s)
+			}
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+func (c *Command) ReadInit(node *yaml.Node) (any, error) {
+	params := &inputParams{
+		commandParams: commandParams{
+			Timeout: defaultInputTimeout,
+		},
+	}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	if params.Name == "" {
+		return nil, errors.New("name must be specified")
+	}
+	p, err := newParser(&params.parseParams)
+	if err != nil {
+		return nil, err
+	}
+	return &inputData{
+		params: params,
+		parser: p,
+	}, nil
+}
+
+func (c *Command) Read(data any) (float64, error) {
+	d := data.(*inputData)
+	ctx, cancel := context.WithTimeout(context.Background(), d.params.Timeout)
+	defer cancel()
+	out, err := run(
+		d.params.command(ctx, d.params.Args, nil),
+		d.params.IgnoreExitCode,
+	)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return 0, fmt.Errorf("%s timed out", d.params.Name)
+		}
+		return 0, err
+	}
+	return d.parser.Parse(out)
+}
+
+func (c *Command) ReadClose(any) {}
+
+func execute(t *template.Template, d *templateData) (string, error) {
+	b := &strings.Builder{}
+	if err := t.Execute(b, d); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 func (c *Command) WriteInit(node *yaml.Node) (any, error) {
 	params := &outputParams{}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	return params, nil
+	if params.Name == "" {
+		return nil, errors.New("name must be specified")
+	}
+	d := &outputData{
+		params: params,
+	}
+	for _, a := range params.Args {
+		t, err := template.New("").Parse(a)
+		if err != nil {
+			return nil, err
+		}
+		d.args = append(d.args, t)
+	}
+	if params.Stdin != "" {
+		t, err := template.New("").Parse(params.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		d.stdin = t
+	}
+	return d, nil
 }
 
+// Write runs the command with the value available to the argument templates,
+// the stdin template, and in the SENSORPI_VALUE and SENSORPI_TIMESTAMP
+// environment variables.
 func (c *Command) Write(data any, v float64) error {
-	params := data.(*outputParams)
-	if err := exec.Command(params.Name, params.Args...).Run(); err != nil {
-		e, ok := err.(*exec.ExitError)
-		if ok {
-			s := string(e.Stderr)
-			if len(s) != 0 {
-				return errors.New(s)
-			}
+	var (
+		d  = data.(*outputData)
+		td = &templateData{
+			Value:     v,
+			Timestamp: time.Now(),
+		}
+		args = []string{}
+	)
+	for _, t := range d.args {
+		a, err := execute(t, td)
+		if err != nil {
+			return err
+		}
+		args = append(args, a)
+	}
+	ctx := context.Background()
+	if d.params.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.params.Timeout)
+		defer cancel()
+	}
+	cmd := d.params.command(ctx, args, map[string]string{
+		"SENSORPI_VALUE":     strconv.FormatFloat(v, 'f', -1, 64),
+		"SENSORPI_TIMESTAMP": td.Timestamp.Format(time.RFC3339),
+	})
+	if d.stdin != nil {
+		s, err := execute(d.stdin, td)
+		if err != nil {
+			return err
 		}
-		return err
+		cmd.Stdin = strings.NewReader(s)
 	}
-	return nil
+	_, err := run(cmd, false)
+	return err
 }
 
 func (c *Command) WriteClose(any) {}
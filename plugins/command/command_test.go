@@ -1,13 +1,249 @@
 package command
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPlugin(t *testing.T) {
+	if !plugin.IsInputPlugin(&Command{}) {
+		t.Fatal("Command does not correctly implement InputPlugin")
+	}
 	if !plugin.IsOutputPlugin(&Command{}) {
 		t.Fatal("Command does not correctly implement OutputPlugin")
 	}
+	if !plugin.IsTriggerPlugin(&Command{}) {
+		t.Fatal("Command does not correctly implement TriggerPlugin")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, v := range []struct {
+		params parseParams
+		output string
+		value  float64
+	}{
+		{parseParams{}, " 21.5\n", 21.5},
+		{parseParams{Regex: `temp=([0-9.]+)`}, "temp=19.25 hum=40", 19.25},
+		{parseParams{Regex: `[0-9]+`}, "count: 42", 42},
+		{parseParams{JSONPath: "sensors.0.value"}, `{"sensors": [{"value": 3}]}`, 3},
+	} {
+		p, err := newParser(&v.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := p.Parse(v.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != v.value {
+			t.Fatalf("%q: %f != %f", v.output, r, v.value)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	c := &Command{}
+	for _, v := range []struct {
+		params string
+		value  float64
+		valid  bool
+	}{
+		{"name: sh\narguments: [-c, echo $VALUE]\nenv: {VALUE: \"12\"}", 12, true},
+		{"name: sh\narguments: [-c, pwd | wc -c]\ndir: /", 2, true},
+		{"name: sh\narguments: [-c, echo 1; exit 1]", 0, false},
+		{"name: sh\narguments: [-c, echo 1; exit 1]\nignore_exit_code: true", 1, true},
+		{"name: sleep\narguments: [\"1\"]\ntimeout: 10ms", 0, false},
+	} {
+		n := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(v.params), n); err != nil {
+			t.Fatal(err)
+		}
+		d, err := c.ReadInit(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := c.Read(d)
+		if !v.valid {
+			if err == nil {
+				t.Fatalf("%q did not fail", v.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != v.value {
+			t.Fatalf("%q: %f != %f", v.params, r, v.value)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var (
+		c        = &Command{}
+		filename = filepath.Join(t.TempDir(), "out")
+	)
+	for _, v := range []struct {
+		params string
+		output string
+	}{
+		{"name: sh\narguments: [-c, \"echo {{.Value}} > $0\", " + filename + "]", "1.5\n"},
+		{"name: sh\narguments: [-c, \"echo $SENSORPI_VALUE > $0\", " + filename + "]", "1.5\n"},
+		{"name: sh\narguments: [-c, \"cat > $0\", " + filename + "]\nstdin: \"value={{.Value}}\"", "value=1.5"},
+	} {
+		n := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(v.params), n); err != nil {
+			t.Fatal(err)
+		}
+		d, err := c.WriteInit(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Write(d, 1.5); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != v.output {
+			t.Fatalf("%q != %q", b, v.output)
+		}
+	}
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte("name: sh\narguments: [-c, \"echo failed >&2; exit 1\"]"), n); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.WriteInit(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(d, 0); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("stderr was not captured: %v", err)
+	}
+}
+
+func TestTimeoutWithChildren(t *testing.T) {
+	c := &Command{}
+
+	// The shell forks sleep, which would keep stdout open for three seconds
+	// if only the shell were killed
+	params := "name: sh\narguments: [-c, sleep 3; echo 1]\ntimeout: 100ms"
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(params), n); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []func() error{
+		func() error {
+			d, err := c.ReadInit(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.Read(d)
+			return err
+		},
+		func() error {
+			d, err := c.WriteInit(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c.Write(d, 1)
+		},
+	} {
+		start := time.Now()
+		if err := f(); err == nil {
+			t.Fatal("command did not time out")
+		}
+		if e := time.Since(start); e > time.Second {
+			t.Fatalf("timeout took %s", e)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var (
+		c = &Command{}
+		n = &yaml.Node{}
+	)
+	if err := yaml.Unmarshal([]byte(`
+name: sh
+arguments:
+  - -c
+  - |
+    echo '{"t": 1}'
+    echo garbage
+    echo '{"t": 2}'
+json_path: t
+restart_delay: 10ms
+`), n); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.WatchInit(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.WatchClose(d)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The process is restarted after exiting, so the values repeat
+	for _, f := range []float64{1, 2, 1, 2} {
+		v, err := c.Watch(d, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != f {
+			t.Fatalf("%f != %f", v, f)
+		}
+	}
+}
+
+func TestWatchLongLine(t *testing.T) {
+	var (
+		c        = &Command{}
+		n        = &yaml.Node{}
+		filename = filepath.Join(t.TempDir(), "started")
+	)
+
+	// The first run writes a line that is too long to read and then hangs;
+	// it must be killed so that the second run can report a value
+	if err := yaml.Unmarshal([]byte(`
+name: sh
+arguments:
+  - -c
+  - |
+    if [ -e "$0" ]; then
+      echo 7
+    else
+      touch "$0"
+      head -c 2000000 /dev/zero | tr '\0' a
+      echo
+    fi
+    sleep 10
+  - `+filename+`
+restart_delay: 10ms
+`), n); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.WatchInit(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.WatchClose(d)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := c.Watch(d, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 7 {
+		t.Fatalf("%f != 7", v)
+	}
 }
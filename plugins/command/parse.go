@@ -0,0 +1,64 @@
+package command
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nathan-osman/sensorpi/jsonpath"
+)
+
+// parseParams determines how a value is extracted from the output of a
+// command; the whole output is parsed as a number unless a regular
+// expression or JSON path is specified
+type parseParams struct {
+	Regex    string `yaml:"regex"`
+	JSONPath string `yaml:"json_path"`
+}
+
+type parser struct {
+	regex    *regexp.Regexp
+	jsonPath string
+}
+
+func newParser(params *parseParams) (*parser, error) {
+	p := &parser{
+		jsonPath: params.JSONPath,
+	}
+	if params.Regex != "" {
+		if params.JSONPath != "" {
+			return nil, errors.New("regex and json_path cannot both be specified")
+		}
+		r, err := regexp.Compile(params.Regex)
+		if err != nil {
+			return nil, err
+		}
+		p.regex = r
+	}
+	return p, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+// Parse extracts a value from the output of a command.
+func (p *parser) Parse(output string) (float64, error) {
+	switch {
+	case p.regex != nil:
+		m := p.regex.FindStringSubmatch(output)
+		if m == nil {
+			return 0, errors.New("regex did not match output")
+		}
+		// Use the first capture group if there is one
+		if len(m) > 1 {
+			return parseFloat(m[1])
+		}
+		return parseFloat(m[0])
+	case p.jsonPath != "":
+		return jsonpath.Extract([]byte(output), p.jsonPath, parseFloat)
+	default:
+		return parseFloat(output)
+	}
+}
@@ -0,0 +1,9 @@
+package command
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where only the process itself is
+// killed; WaitDelay still prevents children from blocking Wait forever
+func setProcessGroup(cmd *exec.Cmd) {}
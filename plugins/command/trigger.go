@@ -0,0 +1,144 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nathan-osman/sensorpi/queue"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultRestartDelay    = time.Second
+	defaultMaxRestartDelay = time.Minute
+	maxLineSize            = 1024 * 1024
+)
+
+type triggerParams struct {
+	commandParams   `yaml:",inline"`
+	parseParams     `yaml:",inline"`
+	queue.Params    `yaml:",inline"`
+	RestartDelay    time.Duration `yaml:"restart_delay"`
+	MaxRestartDelay time.Duration `yaml:"max_restart_delay"`
+}
+
+// triggerData runs a long-running process, queueing a value for each line it
+// writes to stdout
+type triggerData struct {
+	params     *triggerParams
+	parser     *parser
+	queue      *queue.Queue
+	cancel     context.CancelFunc
+	closedChan chan any
+}
+
+func (c *Command) WatchInit(node *yaml.Node) (any, error) {
+	params := &triggerParams{
+		RestartDelay:    defaultRestartDelay,
+		MaxRestartDelay: defaultMaxRestartDelay,
+	}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	if params.Name == "" {
+		return nil, errors.New("name must be specified")
+	}
+	p, err := newParser(&params.parseParams)
+	if err != nil {
+		return nil, err
+	}
+	q, err := queue.New(&params.Params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &triggerData{
+		params:     params,
+		parser:     p,
+		queue:      q,
+		cancel:     cancel,
+		closedChan: make(chan any),
+	}
+	go d.run(ctx)
+	return d, nil
+}
+
+// runOnce runs the process until it exits or the context is cancelled; the
+// process is killed if its output can't be read, since it would otherwise
+// block writing to stdout
+func (d *triggerData) runOnce(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := d.params.command(ctx, d.params.Args, nil)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s := bufio.NewScanner(stdout)
+	s.Buffer(nil, maxLineSize)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" {
+			continue
+		}
+		v, err := d.parser.Parse(l)
+		if err != nil {
+			// Tools that stream readings often print other lines too
+			log.Debug().Msgf("command: %s", err.Error())
+			continue
+		}
+		d.queue.Push(v)
+	}
+	if err := s.Err(); err != nil {
+		log.Warn().Msgf("command: %s: %s", d.params.Name, err.Error())
+		cancel()
+	}
+	return cmd.Wait()
+}
+
+// run restarts the process whenever it exits, doubling the delay each time
+// up to the maximum; the delay is reset once the process has run for longer
+// than the maximum delay
+func (d *triggerData) run(ctx context.Context) {
+	defer close(d.closedChan)
+	delay := d.params.RestartDelay
+	for {
+		start := time.Now()
+		err := d.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > d.params.MaxRestartDelay {
+			delay = d.params.RestartDelay
+		}
+		if err != nil {
+			log.Warn().Msgf("command: %s: %s", d.params.Name, err.Error())
+		}
+		log.Warn().Msgf("command: %s exited; restarting in %s", d.params.Name, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, d.params.MaxRestartDelay)
+	}
+}
+
+func (c *Command) Watch(data any, ctx context.Context) (float64, error) {
+	return data.(*triggerData).queue.Pop(ctx)
+}
+
+// WatchClose kills the process and waits for it to exit.
+func (c *Command) WatchClose(data any) {
+	d := data.(*triggerData)
+	d.cancel()
+	<-d.closedChan
+	d.queue.Close()
+}
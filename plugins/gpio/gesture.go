@@ -0,0 +1,139 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	gesturePress       = "press"
+	gestureDoublePress = "double_press"
+	gestureTriplePress = "triple_press"
+	gestureLongPress   = "long_press"
+	gestureRepeat      = "repeat"
+)
+
+type gestureParams struct {
+	LongPress  time.Duration      `yaml:"long_press"`
+	MultiClick time.Duration      `yaml:"multi_click"`
+	MaxClicks  int                `yaml:"max_clicks"`
+	Repeat     time.Duration      `yaml:"repeat"`
+	Values     map[string]float64 `yaml:"values"`
+}
+
+// gestureData tracks the state of a button between calls to Watch, since a
+// long press may be followed by any number of repeats before it is released
+type gestureData struct {
+	params  *gestureParams
+	values  map[string]float64
+	holding bool
+}
+
+func newGestureData(params *gestureParams) (*gestureData, error) {
+	if params.LongPress == 0 {
+		params.LongPress = time.Second
+	}
+	if params.MultiClick == 0 {
+		params.MultiClick = 400 * time.Millisecond
+	}
+	if params.MaxClicks == 0 {
+		params.MaxClicks = 3
+	}
+	if params.MaxClicks < 1 || params.MaxClicks > 3 {
+		return nil, errors.New("max_clicks must be between 1 and 3")
+	}
+	values := map[string]float64{
+		gesturePress:       1,
+		gestureDoublePress: 2,
+		gestureTriplePress: 3,
+		gestureLongPress:   4,
+		gestureRepeat:      5,
+	}
+	for k, v := range params.Values {
+		if _, ok := values[k]; !ok {
+			return nil, fmt.Errorf("invalid gesture %s", k)
+		}
+		values[k] = v
+	}
+	return &gestureData{
+		params: params,
+		values: values,
+	}, nil
+}
+
+func (g *gestureData) clicks(n int) float64 {
+	switch n {
+	case 1:
+		return g.values[gesturePress]
+	case 2:
+		return g.values[gestureDoublePress]
+	default:
+		return g.values[gestureTriplePress]
+	}
+}
+
+// watchGestures waits for a complete gesture and returns its value.
+func (d *triggerData) watchGestures(ctx context.Context) (float64, error) {
+	g := d.gestures
+	for {
+
+		// If the button is still held after a long press, emit repeats until
+		// it is released
+		if g.holding {
+			active, changed, err := d.waitForChange(ctx, g.params.Repeat)
+			if err != nil {
+				return 0, err
+			}
+			if !changed {
+				return g.values[gestureRepeat], nil
+			}
+			if !active {
+				g.holding = false
+			}
+			continue
+		}
+
+		// Wait for the button to be pressed
+		active, _, err := d.waitForChange(ctx, 0)
+		if err != nil {
+			return 0, err
+		}
+		if !active {
+			continue
+		}
+
+		// Count clicks until the button is held or no further press arrives
+		clicks := 0
+		for {
+			active, changed, err := d.waitForChange(ctx, g.params.LongPress)
+			if err != nil {
+				return 0, err
+			}
+			if !changed {
+				g.holding = true
+				return g.values[gestureLongPress], nil
+			}
+			if active {
+				continue
+			}
+			clicks++
+			if clicks >= g.params.MaxClicks {
+				return g.clicks(clicks), nil
+			}
+			active, changed, err = d.waitForChange(ctx, g.params.MultiClick)
+			if err != nil {
+				return 0, err
+			}
+			if !changed {
+				return g.clicks(clicks), nil
+			}
+			if !active {
+				return g.clicks(clicks), nil
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+)
+
+// step changes the level of the pin after a delay
+type step struct {
+	after time.Duration
+	level gpio.Level
+}
+
+// newTestTrigger creates a trigger for a fake pin without starting the
+// watcher, so that edges can be fed to it by play
+func newTestTrigger(debounce time.Duration) (*triggerData, *gpiotest.Pin) {
+	p := &gpiotest.Pin{N: "GPIO1", L: gpio.Low}
+	return &triggerData{
+		watcher: &gpioWatcher{
+			pin:      p,
+			edgeChan: make(chan any, 1),
+		},
+		lastLevel:        gpio.Low,
+		debounceDuration: debounce,
+		edge:             gpio.BothEdges,
+	}, p
+}
+
+// play applies the steps in the background, signalling an edge for each
+func play(d *triggerData, p *gpiotest.Pin, steps []step) {
+	go func() {
+		for _, s := range steps {
+			time.Sleep(s.after)
+			p.Out(s.level)
+			select {
+			case d.watcher.edgeChan <- nil:
+			default:
+			}
+		}
+	}()
+}
+
+// click presses and releases the button
+func click() []step {
+	return []step{
+		{20 * time.Millisecond, gpio.High},
+		{20 * time.Millisecond, gpio.Low},
+	}
+}
+
+// hold presses the button and releases it after the duration
+func hold(duration time.Duration) []step {
+	return []step{
+		{20 * time.Millisecond, gpio.High},
+		{duration, gpio.Low},
+	}
+}
+
+func concat(steps ...[]step) []step {
+	r := []step{}
+	for _, s := range steps {
+		r = append(r, s...)
+	}
+	return r
+}
+
+func TestWatchGestures(t *testing.T) {
+	for _, v := range []struct {
+		name      string
+		maxClicks int
+		repeat    time.Duration
+		steps     []step
+		values    []float64
+	}{
+		{
+			name:   "press",
+			steps:  click(),
+			values: []float64{1},
+		},
+		{
+			name:   "double press",
+			steps:  concat(click(), click()),
+			values: []float64{2},
+		},
+		{
+			name:   "triple press",
+			steps:  concat(click(), click(), click()),
+			values: []float64{3},
+		},
+		{
+			name:      "max clicks",
+			maxClicks: 2,
+			steps:     concat(click(), click(), click()),
+			values:    []float64{2, 1},
+		},
+		{
+			name:   "long press with repeat",
+			repeat: 100 * time.Millisecond,
+			steps:  concat(hold(300*time.Millisecond), click()),
+			values: []float64{4, 5, 1},
+		},
+		{
+			name:   "release after long press",
+			steps:  concat(hold(300*time.Millisecond), click()),
+			values: []float64{4, 1},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			d, p := newTestTrigger(time.Millisecond)
+			gestures, err := newGestureData(&gestureParams{
+				LongPress:  150 * time.Millisecond,
+				MultiClick: 80 * time.Millisecond,
+				MaxClicks:  v.maxClicks,
+				Repeat:     v.repeat,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.gestures = gestures
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			play(d, p, v.steps)
+			for _, want := range v.values {
+				f, err := (&Gpio{}).Watch(d, ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f != want {
+					t.Fatalf("%f != %f", f, want)
+				}
+			}
+
+			// Nothing else should be emitted once the gestures are complete
+			ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			if f, err := (&Gpio{}).Watch(d, ctx); err == nil {
+				t.Fatalf("unexpected value %f", f)
+			}
+		})
+	}
+}
@@ -4,32 +4,78 @@ package gpio
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 	"periph.io/x/conn/v3/gpio"
-	"periph.io/x/conn/v3/gpio/gpioreg"
 	"periph.io/x/host/v3"
 )
 
+const (
+	modeCount = "count"
+	modeLevel = "level"
+	modeRate  = "rate"
+)
+
 type Gpio struct{}
 
+type inputParams struct {
+	pinParams   `yaml:",inline"`
+	Invert      bool    `yaml:"invert"`
+	PullUpDown  string  `yaml:"pull_up_down"`
+	Edge        string  `yaml:"edge"`
+	Mode        string  `yaml:"mode"`
+	RateUnit    string  `yaml:"rate_unit"`
+	Scale       float64 `yaml:"scale"`
+	ResetOnRead bool    `yaml:"reset_on_read"`
+}
+
+type inputData struct {
+	pin         gpio.PinIO
+	invert      bool
+	watcher     *gpioWatcher
+	mode        string
+	rateUnit    time.Duration
+	scale       float64
+	resetOnRead bool
+	lastCount   uint64
+	lastRead    time.Time
+}
+
 type outputParams struct {
-	Pin int `yaml:"pin"`
+	pinParams `yaml:",inline"`
+	Invert    bool          `yaml:"invert"`
+	Initial   string        `yaml:"initial"`
+	OnClose   string        `yaml:"on_close"`
+	Pulse     time.Duration `yaml:"pulse"`
+	HoldFor   time.Duration `yaml:"hold_for"`
+	PWM       *pwmParams    `yaml:"pwm"`
 }
 
 type outputData struct {
-	pin gpio.PinIO
+	mutex   sync.Mutex
+	pin     gpio.PinIO
+	invert  bool
+	onClose *bool
+	pulse   time.Duration
+	holdFor time.Duration
+	timer   *time.Timer
+	timerId int
 }
 
 type triggerParams struct {
-	Pin              int    `yaml:"pin"`
-	Invert           bool   `yaml:"invert"`
-	PullUpDown       string `yaml:"pull_up_down"`
-	DebounceInterval string `yaml:"debounce_interval"`
+	pinParams        `yaml:",inline"`
+	Invert           bool           `yaml:"invert"`
+	PullUpDown       string         `yaml:"pull_up_down"`
+	DebounceInterval string         `yaml:"debounce_interval"`
+	Edge             string         `yaml:"edge"`
+	EmitInitial      bool           `yaml:"emit_initial"`
+	Gestures         *gestureParams `yaml:"gestures"`
 }
 
 type triggerData struct {
@@ -38,6 +84,10 @@ type triggerData struct {
 	lastLevel        gpio.Level
 	lastLevelTime    time.Time
 	debounceDuration time.Duration
+	recheckTime      time.Time
+	edge             gpio.Edge
+	emitInitial      bool
+	gestures         *gestureData
 }
 
 func init() {
@@ -50,14 +100,6 @@ func init() {
 	})
 }
 
-func pinByName(pin int) (gpio.PinIO, error) {
-	p := gpioreg.ByName(strconv.Itoa(pin))
-	if p == nil {
-		return nil, fmt.Errorf("GPIO pin %d does not exist", pin)
-	}
-	return p, nil
-}
-
 func parsePullUpDown(v string) (gpio.Pull, error) {
 	switch v {
 	case "":
@@ -71,39 +113,222 @@ func parsePullUpDown(v string) (gpio.Pull, error) {
 	}
 }
 
-func (g *Gpio) WriteInit(node *yaml.Node) (any, error) {
-	params := &outputParams{}
+func parseEdge(v string, def gpio.Edge) (gpio.Edge, error) {
+	switch v {
+	case "":
+		return def, nil
+	case "rising":
+		return gpio.RisingEdge, nil
+	case "falling":
+		return gpio.FallingEdge, nil
+	case "both":
+		return gpio.BothEdges, nil
+	default:
+		return 0, fmt.Errorf("invalid value %s for edge", v)
+	}
+}
+
+func parseRateUnit(v string) (time.Duration, error) {
+	switch v {
+	case "", "second":
+		return time.Second, nil
+	case "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid value %s for rate unit", v)
+	}
+}
+
+func (g *Gpio) ReadInit(node *yaml.Node) (any, error) {
+	params := &inputParams{
+		Mode:  modeCount,
+		Scale: 1,
+	}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	p, err := pinByName(params.Pin)
+	switch params.Mode {
+	case modeCount, modeLevel, modeRate:
+	default:
+		return nil, fmt.Errorf("invalid mode %s", params.Mode)
+	}
+	p, err := params.open()
+	if err != nil {
+		return nil, err
+	}
+	pull, err := parsePullUpDown(params.PullUpDown)
+	if err != nil {
+		return nil, err
+	}
+	if params.Mode == modeLevel {
+		if err := p.In(pull, gpio.NoEdge); err != nil {
+			return nil, err
+		}
+		return &inputData{
+			pin:    p,
+			invert: params.Invert,
+			mode:   params.Mode,
+		}, nil
+	}
+	edge, err := parseEdge(params.Edge, gpio.RisingEdge)
+	if err != nil {
+		return nil, err
+	}
+	rateUnit, err := parseRateUnit(params.RateUnit)
 	if err != nil {
 		return nil, err
 	}
-	return &outputData{
-		pin: p,
+	if err := p.In(pull, edge); err != nil {
+		return nil, err
+	}
+	return &inputData{
+		pin:         p,
+		watcher:     newGpioWatcher(p),
+		mode:        params.Mode,
+		rateUnit:    rateUnit,
+		scale:       params.Scale,
+		resetOnRead: params.ResetOnRead,
+		lastRead:    time.Now(),
 	}, nil
 }
 
-func (g *Gpio) Write(data any, v float64) error {
+func (g *Gpio) Read(data any) (float64, error) {
+	d := data.(*inputData)
+	if d.mode == modeLevel {
+		if (d.pin.Read() == gpio.High) != d.invert {
+			return 1, nil
+		}
+		return 0, nil
+	}
 	var (
-		d = data.(*outputData)
-		l = gpio.High
+		n       = time.Now()
+		count   = d.watcher.Count()
+		delta   = count - d.lastCount
+		elapsed = n.Sub(d.lastRead)
+		v       float64
 	)
-	if v == 0 {
-		l = gpio.Low
+	switch d.mode {
+	case modeCount:
+		if d.resetOnRead {
+			v = float64(delta)
+			d.lastCount = count
+		} else {
+			v = float64(count)
+		}
+	case modeRate:
+		if elapsed > 0 {
+			v = float64(delta) / float64(elapsed) * float64(d.rateUnit)
+		}
+		d.lastCount = count
+	}
+	d.lastRead = n
+	return v * d.scale, nil
+}
+
+func (g *Gpio) ReadClose(data any) {
+	if d := data.(*inputData); d.watcher != nil {
+		d.watcher.Close()
+	}
+}
+
+func (g *Gpio) WriteInit(node *yaml.Node) (any, error) {
+	params := &outputParams{}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	if params.Pulse != 0 && params.HoldFor != 0 {
+		return nil, errors.New("pulse and hold_for cannot both be specified")
+	}
+	if params.PWM != nil &&
+		(params.Initial != "" || params.OnClose != "" || params.Pulse != 0 || params.HoldFor != 0) {
+		return nil, errors.New("initial, on_close, pulse and hold_for cannot be used with pwm")
+	}
+	p, err := params.open()
+	if err != nil {
+		return nil, err
+	}
+	if params.PWM != nil {
+		return newPwmOutput(p, params.PWM, params.Invert), nil
+	}
+	initial, err := parseOnOff(params.Initial)
+	if err != nil {
+		return nil, err
+	}
+	onClose, err := parseOnOff(params.OnClose)
+	if err != nil {
+		return nil, err
+	}
+	d := &outputData{
+		pin:     p,
+		invert:  params.Invert,
+		onClose: onClose,
+		pulse:   params.Pulse,
+		holdFor: params.HoldFor,
+	}
+	if initial != nil {
+		if err := d.set(*initial); err != nil {
+			return nil, err
+		}
 	}
-	return d.pin.Out(l)
+	return d, nil
 }
 
-func (g *Gpio) WriteClose(any) {}
+func (g *Gpio) Write(data any, v float64) error {
+	if o, ok := data.(*pwmOutput); ok {
+		return o.Write(v)
+	}
+	d := data.(*outputData)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	switch {
+	case d.pulse != 0:
+		if v == 0 || d.timer != nil {
+			return nil
+		}
+		return d.setFor(d.pulse)
+	case d.holdFor != 0:
+		if v == 0 {
+			return nil
+		}
+		return d.setFor(d.holdFor)
+	default:
+		return d.set(v != 0)
+	}
+}
+
+func (g *Gpio) WriteClose(data any) {
+	if o, ok := data.(*pwmOutput); ok {
+		o.Close()
+		return
+	}
+	d := data.(*outputData)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil {
+		d.cancelTimer()
+		if err := d.set(false); err != nil {
+			log.Warn().Msgf("gpio: %s", err.Error())
+		}
+	}
+	if d.onClose != nil {
+		if err := d.set(*d.onClose); err != nil {
+			log.Warn().Msgf("gpio: %s", err.Error())
+		}
+	}
+}
 
 func (g *Gpio) WatchInit(node *yaml.Node) (any, error) {
 	params := &triggerParams{}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	p, err := pinByName(params.Pin)
+	if params.EmitInitial && params.Gestures != nil {
+		// The raw level would be indistinguishable from a gesture value
+		return nil, errors.New("emit_initial cannot be used with gestures")
+	}
+	p, err := params.open()
 	if err != nil {
 		return nil, err
 	}
@@ -111,12 +336,12 @@ func (g *Gpio) WatchInit(node *yaml.Node) (any, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := p.In(pull, gpio.BothEdges); err != nil {
+	edge, err := parseEdge(params.Edge, gpio.BothEdges)
+	if err != nil {
 		return nil, err
 	}
-	lastLevel := gpio.Low
-	if params.Invert {
-		lastLevel = gpio.High
+	if err := p.In(pull, gpio.BothEdges); err != nil {
+		return nil, err
 	}
 	var debounceDuration time.Duration
 	if params.DebounceInterval != "" {
@@ -129,49 +354,120 @@ func (g *Gpio) WatchInit(node *yaml.Node) (any, error) {
 	if debounceDuration == 0 {
 		debounceDuration = 200 * time.Millisecond
 	}
+	var gestures *gestureData
+	if params.Gestures != nil {
+		g, err := newGestureData(params.Gestures)
+		if err != nil {
+			return nil, err
+		}
+		gestures = g
+	}
 	return &triggerData{
 		watcher:          newGpioWatcher(p),
 		invert:           params.Invert,
-		lastLevel:        lastLevel,
+		lastLevel:        p.Read(),
 		debounceDuration: debounceDuration,
+		edge:             edge,
+		emitInitial:      params.EmitInitial,
+		gestures:         gestures,
 	}, nil
 }
 
-func (g *Gpio) Watch(data any, ctx context.Context) (float64, error) {
-	d := data.(*triggerData)
+func (d *triggerData) isActive(l gpio.Level) bool {
+	return (l == gpio.High) != d.invert
+}
+
+// edgeMatches returns false if a change to the value is filtered out by the
+// edge parameter
+func (d *triggerData) edgeMatches(active bool) bool {
+	return !((d.edge == gpio.RisingEdge && !active) ||
+		(d.edge == gpio.FallingEdge && active))
+}
+
+// waitForChange waits for the debounced level of the pin to change and
+// returns true if it is now active (high, unless inverted). If timeout is
+// non-zero and elapses first, changed is false.
+func (d *triggerData) waitForChange(ctx context.Context, timeout time.Duration) (active, changed bool, err error) {
+	var timeoutChan <-chan time.Time
+	if timeout != 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutChan = t.C
+	}
 	for {
+		var recheckChan <-chan time.Time
+		if !d.recheckTime.IsZero() {
+			recheckChan = time.After(time.Until(d.recheckTime))
+		}
 		select {
 		case <-d.watcher.edgeChan:
 
 			var n = time.Now()
 
-			// Don't do anything if the debounce interval hasn't elapsed
+			// If the debounce interval hasn't elapsed, the level may still be
+			// settling, so check it again once the interval has elapsed
 			if n.Before(d.lastLevelTime.Add(d.debounceDuration)) {
+				d.recheckTime = d.lastLevelTime.Add(d.debounceDuration)
 				continue
 			}
 
-			// The pin has changed value, so invert it
-			d.lastLevel = !d.lastLevel
-			d.lastLevelTime = n
+		case <-recheckChan:
 
-			var (
-				isHigh = d.lastLevel == gpio.High
-				v      float64
-			)
-			if d.invert {
-				isHigh = !isHigh
-			}
-			if isHigh {
-				v = 1
-			}
+			d.recheckTime = time.Time{}
+
+		case <-timeoutChan:
 
-			// Return the new value
-			return v, nil
+			return false, false, nil
 
 		case <-ctx.Done():
 
-			return 0, context.Canceled
+			return false, false, context.Canceled
+		}
+
+		// Read the actual level, ignoring edges that didn't change it
+		l := d.watcher.pin.Read()
+		if l == d.lastLevel {
+			continue
+		}
+		d.lastLevel = l
+		d.lastLevelTime = time.Now()
+
+		return d.isActive(l), true, nil
+	}
+}
+
+func (g *Gpio) Watch(data any, ctx context.Context) (float64, error) {
+	d := data.(*triggerData)
+
+	// The initial value is subject to the same edge filter as changes, so a
+	// trigger for rising edges doesn't start by reporting 0
+	if d.emitInitial {
+		d.emitInitial = false
+		if active := d.isActive(d.lastLevel); d.edgeMatches(active) {
+			if active {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if d.gestures != nil {
+		return d.watchGestures(ctx)
+	}
+	for {
+		active, _, err := d.waitForChange(ctx, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		// Edges refer to the value being reported, so "rising" is a change
+		// from 0 to 1 even if the pin is inverted
+		if !d.edgeMatches(active) {
+			continue
+		}
+		if active {
+			return 1, nil
 		}
+		return 0, nil
 	}
 }
 
@@ -3,12 +3,23 @@
 package gpio
 
 import (
+	"context"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nathan-osman/sensorpi/plugin"
+	"gopkg.in/yaml.v3"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+	"periph.io/x/conn/v3/physic"
 )
 
 func TestPlugin(t *testing.T) {
+	if !plugin.IsInputPlugin(&Gpio{}) {
+		t.Fatal("Gpio does not correctly implement InputPlugin")
+	}
 	if !plugin.IsOutputPlugin(&Gpio{}) {
 		t.Fatal("Gpio does not correctly implement OutputPlugin")
 	}
@@ -16,3 +27,319 @@ func TestPlugin(t *testing.T) {
 		t.Fatal("Gpio does not correctly implement TriggerPlugin")
 	}
 }
+
+func TestPwmDuty(t *testing.T) {
+	o := &pwmOutput{
+		params: &pwmParams{
+			Min:      0,
+			Max:      180,
+			Servo:    true,
+			MinPulse: time.Millisecond,
+			MaxPulse: 2 * time.Millisecond,
+			MaxAngle: 180,
+		},
+		frequency: 50 * physic.Hertz,
+	}
+	for _, v := range []struct {
+		value float64
+		duty  float64
+	}{
+		{0, 0.05},
+		{90, 0.075},
+		{180, 0.1},
+		{270, 0.1},
+	} {
+		if d := o.dutyFor(v.value); math.Abs(d-v.duty) > 1e-9 {
+			t.Fatalf("%f != %f", d, v.duty)
+		}
+	}
+}
+
+// waitForLevel polls the pin until it reaches the level or a second passes
+func waitForLevel(t *testing.T, p *gpiotest.Pin, l gpio.Level) {
+	for i := 0; i < 100; i++ {
+		if p.Read() == l {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pin did not reach %s", l)
+}
+
+func TestSoftwarePwm(t *testing.T) {
+	p := &gpiotest.Pin{N: "GPIO1"}
+	o := newPwmOutput(p, &pwmParams{Software: true}, false)
+	defer o.Close()
+	if o.frequency != defaultSoftwareFrequency*physic.Hertz {
+		t.Fatalf("%s != %d Hz", o.frequency, defaultSoftwareFrequency)
+	}
+	o.Write(100)
+	waitForLevel(t, p, gpio.High)
+	o.Write(0)
+	waitForLevel(t, p, gpio.Low)
+}
+
+func TestWaitForChange(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		steps   []step
+		active  bool
+		changed bool
+		minTime time.Duration
+	}{
+		{
+			name:    "change",
+			steps:   []step{{0, gpio.High}},
+			active:  true,
+			changed: true,
+		},
+		{
+			name:    "edge without change",
+			steps:   []step{{0, gpio.Low}},
+			changed: false,
+		},
+		{
+			name:    "settled within debounce",
+			steps:   []step{{0, gpio.High}},
+			active:  true,
+			changed: true,
+			minTime: 50 * time.Millisecond,
+		},
+		{
+			name: "bounce within debounce",
+			steps: []step{
+				{0, gpio.High},
+				{10 * time.Millisecond, gpio.Low},
+			},
+			changed: false,
+			minTime: 50 * time.Millisecond,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			d, p := newTestTrigger(v.minTime)
+			if v.minTime != 0 {
+				// Pretend the level only just changed, so that the edges
+				// arrive within the debounce interval
+				d.lastLevelTime = time.Now()
+			}
+			start := time.Now()
+			play(d, p, v.steps)
+			active, changed, err := d.waitForChange(context.Background(), 200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active != v.active || changed != v.changed {
+				t.Fatalf("%t, %t != %t, %t", active, changed, v.active, v.changed)
+			}
+			if e := time.Since(start); changed && e < v.minTime {
+				t.Fatalf("%s < %s", e, v.minTime)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for _, v := range []struct {
+		name        string
+		edge        gpio.Edge
+		invert      bool
+		emitInitial bool
+		values      []float64
+	}{
+		{
+			name:   "both",
+			edge:   gpio.BothEdges,
+			values: []float64{1, 0, 1, 0},
+		},
+		{
+			name:   "rising",
+			edge:   gpio.RisingEdge,
+			values: []float64{1, 1},
+		},
+		{
+			name:   "falling",
+			edge:   gpio.FallingEdge,
+			values: []float64{0, 0},
+		},
+		{
+			name:   "falling inverted",
+			edge:   gpio.FallingEdge,
+			invert: true,
+			values: []float64{0, 0},
+		},
+		{
+			name:        "initial",
+			edge:        gpio.BothEdges,
+			emitInitial: true,
+			values:      []float64{0, 1, 0, 1, 0},
+		},
+		{
+			name:        "initial filtered by edge",
+			edge:        gpio.RisingEdge,
+			emitInitial: true,
+			values:      []float64{1, 1},
+		},
+		{
+			name:        "initial matching edge",
+			edge:        gpio.FallingEdge,
+			emitInitial: true,
+			values:      []float64{0, 0, 0},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			d, p := newTestTrigger(time.Millisecond)
+			d.edge = v.edge
+			d.invert = v.invert
+			d.emitInitial = v.emitInitial
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			// Each click produces a rising and then a falling edge, except
+			// when inverted, where the pin is pressed by pulling it low
+			steps := concat(click(), click())
+			if v.invert {
+				d.lastLevel = gpio.High
+				p.Out(gpio.High)
+				for i := range steps {
+					steps[i].level = !steps[i].level
+				}
+			}
+			play(d, p, steps)
+			for _, want := range v.values {
+				f, err := (&Gpio{}).Watch(d, ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f != want {
+					t.Fatalf("%f != %f", f, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchInitialWithGestures(t *testing.T) {
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte("pin: GPIO1\nemit_initial: true\ngestures: {}\n"), node); err != nil {
+		t.Fatal(err)
+	}
+	_, err := (&Gpio{}).WatchInit(node.Content[0])
+	if err == nil || !strings.Contains(err.Error(), "emit_initial") {
+		t.Fatalf("emit_initial with gestures should be rejected: %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, v := range []struct {
+		name        string
+		mode        string
+		rateUnit    string
+		scale       float64
+		resetOnRead bool
+		edges       []uint64
+		values      []float64
+	}{
+		{
+			name:   "count",
+			mode:   modeCount,
+			edges:  []uint64{3, 2},
+			values: []float64{3, 5},
+		},
+		{
+			name:        "count with reset on read",
+			mode:        modeCount,
+			resetOnRead: true,
+			edges:       []uint64{3, 2, 0},
+			values:      []float64{3, 2, 0},
+		},
+		{
+			name:   "scaled count",
+			mode:   modeCount,
+			scale:  0.5,
+			edges:  []uint64{3, 2},
+			values: []float64{1.5, 2.5},
+		},
+		{
+			name:   "rate per second",
+			mode:   modeRate,
+			edges:  []uint64{10, 5},
+			values: []float64{10, 5},
+		},
+		{
+			name:     "rate per minute",
+			mode:     modeRate,
+			rateUnit: "minute",
+			edges:    []uint64{10},
+			values:   []float64{600},
+		},
+		{
+			name:   "scaled rate",
+			mode:   modeRate,
+			scale:  2,
+			edges:  []uint64{10},
+			values: []float64{20},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			rateUnit, err := parseRateUnit(v.rateUnit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.scale == 0 {
+				v.scale = 1
+			}
+			p := &gpiotest.Pin{N: "GPIO1"}
+			d := &inputData{
+				pin: p,
+				watcher: &gpioWatcher{
+					pin:      p,
+					edgeChan: make(chan any, 1),
+				},
+				mode:        v.mode,
+				rateUnit:    rateUnit,
+				scale:       v.scale,
+				resetOnRead: v.resetOnRead,
+			}
+			for i, e := range v.edges {
+
+				// Pretend that each read is a second after the last, so that
+				// the rate is the number of edges per second
+				d.lastRead = time.Now().Add(-time.Second)
+				d.watcher.count.Add(e)
+				f, err := (&Gpio{}).Read(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(f-v.values[i]) > v.values[i]*0.01 {
+					t.Fatalf("%f != %f", f, v.values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadLevel(t *testing.T) {
+	for _, v := range []struct {
+		level  gpio.Level
+		invert bool
+		value  float64
+	}{
+		{gpio.Low, false, 0},
+		{gpio.High, false, 1},
+		{gpio.Low, true, 1},
+		{gpio.High, true, 0},
+	} {
+		d := &inputData{
+			pin:    &gpiotest.Pin{N: "GPIO1", L: v.level},
+			invert: v.invert,
+			mode:   modeLevel,
+		}
+		f, err := (&Gpio{}).Read(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != v.value {
+			t.Fatalf("%f != %f", f, v.value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"periph.io/x/conn/v3/gpio"
+)
+
+// parseOnOff parses an optional logical level; nil is returned if the value
+// is empty, indicating that the pin should be left alone
+func parseOnOff(v string) (*bool, error) {
+	var on bool
+	switch v {
+	case "":
+		return nil, nil
+	case "on", "high":
+		on = true
+	case "off", "low":
+		on = false
+	default:
+		return nil, fmt.Errorf("invalid value %s for level", v)
+	}
+	return &on, nil
+}
+
+// set drives the pin to the provided logical level, taking inversion (for
+// active-low relays, for example) into account
+func (d *outputData) set(on bool) error {
+	return d.pin.Out(gpio.Level(on != d.invert))
+}
+
+// setFor turns the pin on and (re)starts the timer that turns it off again;
+// the mutex must be held
+func (d *outputData) setFor(duration time.Duration) error {
+	if err := d.set(true); err != nil {
+		return err
+	}
+	d.cancelTimer()
+	id := d.timerId
+	d.timer = time.AfterFunc(duration, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+
+		// The timer may have been replaced after it fired but before the
+		// mutex was acquired
+		if id != d.timerId {
+			return
+		}
+		d.timer = nil
+		if err := d.set(false); err != nil {
+			log.Error().Msgf("gpio: %s", err.Error())
+		}
+	})
+	return nil
+}
+
+// cancelTimer stops a pending timer; the mutex must be held
+func (d *outputData) cancelTimer() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.timerId++
+}
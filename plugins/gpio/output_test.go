@@ -0,0 +1,164 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+)
+
+// write is a single write to an output after a delay
+type write struct {
+	after time.Duration
+	value float64
+}
+
+func TestWrite(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		data   *outputData
+		writes []write
+		at     time.Duration
+		level  gpio.Level
+	}{
+		{
+			name:   "level",
+			data:   &outputData{},
+			writes: []write{{0, 1}},
+			at:     10 * time.Millisecond,
+			level:  gpio.High,
+		},
+		{
+			name:   "inverted",
+			data:   &outputData{invert: true},
+			writes: []write{{0, 1}},
+			at:     10 * time.Millisecond,
+			level:  gpio.Low,
+		},
+		{
+			name:   "pulse",
+			data:   &outputData{pulse: 100 * time.Millisecond},
+			writes: []write{{0, 1}},
+			at:     150 * time.Millisecond,
+			level:  gpio.Low,
+		},
+		{
+			name:   "pulse ignores writes while active",
+			data:   &outputData{pulse: 100 * time.Millisecond},
+			writes: []write{{0, 1}, {50 * time.Millisecond, 1}},
+			at:     125 * time.Millisecond,
+			level:  gpio.Low,
+		},
+		{
+			name:   "pulse ignores zero",
+			data:   &outputData{pulse: 100 * time.Millisecond},
+			writes: []write{{0, 1}, {20 * time.Millisecond, 0}},
+			at:     50 * time.Millisecond,
+			level:  gpio.High,
+		},
+		{
+			name:   "hold_for",
+			data:   &outputData{holdFor: 100 * time.Millisecond},
+			writes: []write{{0, 1}},
+			at:     150 * time.Millisecond,
+			level:  gpio.Low,
+		},
+		{
+			name:   "hold_for restarts",
+			data:   &outputData{holdFor: 100 * time.Millisecond},
+			writes: []write{{0, 1}, {50 * time.Millisecond, 1}},
+			at:     125 * time.Millisecond,
+			level:  gpio.High,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			p := &gpiotest.Pin{N: "GPIO1"}
+			v.data.pin = p
+			start := time.Now()
+			for _, w := range v.writes {
+				time.Sleep(time.Until(start.Add(w.after)))
+				if err := (&Gpio{}).Write(v.data, w.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.Sleep(time.Until(start.Add(v.at)))
+			if l := p.Read(); l != v.level {
+				t.Fatalf("%s != %s", l, v.level)
+			}
+		})
+	}
+}
+
+func TestSetForRace(t *testing.T) {
+	p := &gpiotest.Pin{N: "GPIO1"}
+	d := &outputData{pin: p}
+
+	// Let the first timer fire while the mutex is held, then replace it; the
+	// first timer must not turn the pin off once it acquires the mutex
+	d.mutex.Lock()
+	if err := d.setFor(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := d.setFor(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	d.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if l := p.Read(); l != gpio.High {
+		t.Fatalf("%s != %s", l, gpio.High)
+	}
+	(&Gpio{}).WriteClose(d)
+}
+
+func TestWriteClose(t *testing.T) {
+	var (
+		on  = true
+		off = false
+	)
+	for _, v := range []struct {
+		name    string
+		onClose *bool
+		level   gpio.Level
+	}{
+		{
+			name:  "pending timer",
+			level: gpio.Low,
+		},
+		{
+			name:    "on_close on",
+			onClose: &on,
+			level:   gpio.High,
+		},
+		{
+			name:    "on_close off",
+			onClose: &off,
+			level:   gpio.Low,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			p := &gpiotest.Pin{N: "GPIO1"}
+			d := &outputData{
+				pin:     p,
+				onClose: v.onClose,
+				holdFor: 100 * time.Millisecond,
+			}
+			if err := (&Gpio{}).Write(d, 1); err != nil {
+				t.Fatal(err)
+			}
+			(&Gpio{}).WriteClose(d)
+			if l := p.Read(); l != v.level {
+				t.Fatalf("%s != %s", l, v.level)
+			}
+
+			// The cancelled timer must not change the level afterwards
+			time.Sleep(150 * time.Millisecond)
+			if l := p.Read(); l != v.level {
+				t.Fatalf("%s != %s", l, v.level)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/host/v3/gpioioctl"
+)
+
+// pinParams identifies a pin either by name (a GPIO number, header name like
+// P1_11, or alias) or by a line on a GPIO character device, which allows
+// lines on expanders to be used
+type pinParams struct {
+	Pin  string `yaml:"pin"`
+	Chip string `yaml:"chip"`
+	Line string `yaml:"line"`
+}
+
+func chipByName(name string) *gpioioctl.GPIOChip {
+	for _, c := range gpioioctl.Chips {
+		if c.Name() == name || c.Label() == name || c.Path() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (p *pinParams) open() (gpio.PinIO, error) {
+	if p.Chip == "" {
+		if p.Pin == "" {
+			return nil, errors.New("GPIO pin must be specified")
+		}
+		pin := gpioreg.ByName(p.Pin)
+		if pin == nil {
+			return nil, fmt.Errorf("GPIO pin %s does not exist", p.Pin)
+		}
+		return pin, nil
+	}
+	c := chipByName(p.Chip)
+	if c == nil {
+		return nil, fmt.Errorf("GPIO chip %s does not exist", p.Chip)
+	}
+	var line *gpioioctl.GPIOLine
+	if n, err := strconv.Atoi(p.Line); err == nil {
+		if n >= 0 && n < c.LineCount() {
+			line = c.ByNumber(n)
+		}
+	} else {
+		line = c.ByName(p.Line)
+	}
+	if line == nil {
+		return nil, fmt.Errorf("line %s does not exist on GPIO chip %s", p.Line, p.Chip)
+	}
+	return line, nil
+}
@@ -0,0 +1,233 @@
+//go:build !windows
+
+package gpio
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/physic"
+)
+
+const (
+	rampInterval = 20 * time.Millisecond
+
+	defaultFrequency         = 1000
+	defaultSoftwareFrequency = 100
+)
+
+type pwmParams struct {
+	Frequency float64       `yaml:"frequency"`
+	Min       float64       `yaml:"min"`
+	Max       float64       `yaml:"max"`
+	Ramp      time.Duration `yaml:"ramp"`
+	Software  bool          `yaml:"software"`
+	Servo     bool          `yaml:"servo"`
+	MinPulse  time.Duration `yaml:"min_pulse"`
+	MaxPulse  time.Duration `yaml:"max_pulse"`
+	MaxAngle  float64       `yaml:"max_angle"`
+}
+
+// pwmOutput drives a pin with a duty cycle derived from the value written to
+// it; hardware PWM is used where available with a software fallback
+type pwmOutput struct {
+	mutex      sync.Mutex
+	pin        gpio.PinIO
+	params     *pwmParams
+	invert     bool
+	frequency  physic.Frequency
+	software   bool
+	current    float64
+	target     float64
+	applied    float64
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func newPwmOutput(p gpio.PinIO, params *pwmParams, invert bool) *pwmOutput {
+	if params.Servo {
+		if params.Frequency == 0 {
+			params.Frequency = 50
+		}
+		if params.MinPulse == 0 {
+			params.MinPulse = time.Millisecond
+		}
+		if params.MaxPulse == 0 {
+			params.MaxPulse = 2 * time.Millisecond
+		}
+		if params.MaxAngle == 0 {
+			params.MaxAngle = 180
+		}
+		if params.Max == 0 {
+			params.Max = params.MaxAngle
+		}
+	}
+	// Software PWM costs two sleeps and two writes per period, so it uses a
+	// lower frequency unless one was specified
+	frequencySet := params.Frequency != 0
+	if !frequencySet {
+		params.Frequency = defaultFrequency
+	}
+	if params.Max == 0 {
+		params.Max = 100
+	}
+	o := &pwmOutput{
+		pin:        p,
+		params:     params,
+		invert:     invert,
+		frequency:  physic.Frequency(params.Frequency * float64(physic.Hertz)),
+		software:   params.Software,
+		applied:    -1,
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	if !o.software {
+		if err := o.pin.PWM(o.toDuty(0), o.frequency); err != nil {
+			log.Info().Msgf("gpio: falling back to software PWM: %s", err.Error())
+			o.software = true
+		} else {
+			o.applied = 0
+		}
+	}
+	if o.software && !frequencySet {
+		params.Frequency = defaultSoftwareFrequency
+		o.frequency = physic.Frequency(params.Frequency * float64(physic.Hertz))
+	}
+	go o.run()
+	return o
+}
+
+// dutyFor maps a value (percentage or angle) to a duty cycle between 0 and 1
+func (o *pwmOutput) dutyFor(v float64) float64 {
+	v = math.Max(o.params.Min, math.Min(o.params.Max, v))
+	if o.params.Servo {
+		var (
+			pulseRange = float64(o.params.MaxPulse - o.params.MinPulse)
+			pulse      = float64(o.params.MinPulse) + v/o.params.MaxAngle*pulseRange
+		)
+		return pulse / float64(o.frequency.Period())
+	}
+	return v / 100
+}
+
+func (o *pwmOutput) toDuty(d float64) gpio.Duty {
+	if o.invert {
+		d = 1 - d
+	}
+	return gpio.Duty(math.Round(d * float64(gpio.DutyMax)))
+}
+
+// step moves the current duty cycle towards the target, limited by the ramp
+// duration; the mutex must be held
+func (o *pwmOutput) step(elapsed time.Duration) {
+	if o.params.Ramp == 0 {
+		o.current = o.target
+		return
+	}
+	maxDelta := float64(elapsed) / float64(o.params.Ramp)
+	switch {
+	case o.target > o.current:
+		o.current = math.Min(o.target, o.current+maxDelta)
+	case o.target < o.current:
+		o.current = math.Max(o.target, o.current-maxDelta)
+	}
+}
+
+func (o *pwmOutput) run() {
+	defer close(o.closedChan)
+	var (
+		last   = time.Now()
+		period = o.frequency.Period()
+	)
+	for {
+		now := time.Now()
+		o.mutex.Lock()
+		o.step(now.Sub(last))
+		var (
+			duty    = o.current
+			ramping = o.current != o.target
+		)
+		o.mutex.Unlock()
+		last = now
+		if o.software && (duty <= 0 || duty >= 1) && !ramping {
+			// A constant level needs no switching, so set it once and wait
+			// for a new target
+			if err := o.pin.Out(gpio.Level((duty >= 1) != o.invert)); err != nil {
+				log.Error().Msgf("gpio: %s", err.Error())
+			}
+			select {
+			case <-o.wakeChan:
+				last = time.Now()
+			case <-o.closeChan:
+				return
+			}
+			continue
+		}
+		if o.software {
+			var (
+				onTime  = time.Duration(duty * float64(period))
+				offTime = period - onTime
+			)
+			if onTime > 0 {
+				o.pin.Out(gpio.Level(!o.invert))
+				time.Sleep(onTime)
+			}
+			if offTime > 0 {
+				o.pin.Out(gpio.Level(o.invert))
+				time.Sleep(offTime)
+			}
+			select {
+			case <-o.closeChan:
+				return
+			default:
+			}
+			continue
+		}
+		if duty != o.applied {
+			if err := o.pin.PWM(o.toDuty(duty), o.frequency); err != nil {
+				log.Error().Msgf("gpio: %s", err.Error())
+			}
+			o.applied = duty
+		}
+		var rampChan <-chan time.Time
+		if ramping {
+			rampChan = time.After(rampInterval)
+		}
+		select {
+		case <-o.wakeChan:
+			if !ramping {
+				// Don't count the time spent idle towards the ramp
+				last = time.Now()
+			}
+		case <-rampChan:
+		case <-o.closeChan:
+			return
+		}
+	}
+}
+
+// Write sets the target value.
+func (o *pwmOutput) Write(v float64) error {
+	o.mutex.Lock()
+	o.target = o.dutyFor(v)
+	o.mutex.Unlock()
+	select {
+	case o.wakeChan <- nil:
+	default:
+	}
+	return nil
+}
+
+// Close stops the PWM output and leaves the pin off.
+func (o *pwmOutput) Close() {
+	close(o.closeChan)
+	<-o.closedChan
+	if err := o.pin.Out(gpio.Level(o.invert)); err != nil {
+		log.Warn().Msgf("gpio: %s", err.Error())
+	}
+}
@@ -1,10 +1,15 @@
 package gpio
 
-import "periph.io/x/conn/v3/gpio"
+import (
+	"sync/atomic"
+
+	"periph.io/x/conn/v3/gpio"
+)
 
 type gpioWatcher struct {
 	pin      gpio.PinIO
 	edgeChan chan any
+	count    atomic.Uint64
 }
 
 func (w *gpioWatcher) run() {
@@ -14,6 +19,7 @@ func (w *gpioWatcher) run() {
 		if !ok {
 			break
 		}
+		w.count.Add(1)
 		select {
 		case w.edgeChan <- nil:
 		default:
@@ -30,6 +36,11 @@ func newGpioWatcher(p gpio.PinIO) *gpioWatcher {
 	return w
 }
 
+// Count returns the total number of edges seen since the watcher started.
+func (w *gpioWatcher) Count() uint64 {
+	return w.count.Load()
+}
+
 func (w *gpioWatcher) Close() {
 
 	// Halt() will cause WaitForEdge() to return false, triggering run() to
@@ -2,28 +2,58 @@ package moisture
 
 import (
 	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
 
 	"github.com/nathan-osman/sensorpi/plugin"
 	"gopkg.in/yaml.v3"
-	"periph.io/x/conn/v3"
 	"periph.io/x/conn/v3/i2c"
 	"periph.io/x/conn/v3/i2c/i2creg"
 	"periph.io/x/host/v3"
 )
 
+const (
+	defaultBus     = "1"
+	defaultAddress = 0x08
+
+	registerRaw     = "raw"
+	registerVoltage = "voltage"
+	registerRatio   = "ratio"
+
+	numChannels = 8
+)
+
+// Base register for each channel of the register sets provided by the Grove
+// base hat
+var registers = map[string]byte{
+	registerRaw:     0x10,
+	registerVoltage: 0x20,
+	registerRatio:   0x30,
+}
+
 // Moisture communicates with the Seeed Studio moisture sensor using the I2C
 // bus.
 type Moisture struct {
-	conn conn.Conn
+	mutex sync.Mutex
+	buses map[string]i2c.BusCloser
 }
 
 type inputParams struct {
-	Channel int `yaml:"channel"`
+	Bus      string   `yaml:"bus"`
+	Address  uint16   `yaml:"address"`
+	Channel  int      `yaml:"channel"`
+	Register string   `yaml:"register"`
+	Dry      *float64 `yaml:"dry"`
+	Wet      *float64 `yaml:"wet"`
 }
 
 type inputData struct {
-	W []byte
-	R []byte
+	dev *i2c.Dev
+	W   []byte
+	R   []byte
+	dry *float64
+	wet *float64
 }
 
 func init() {
@@ -32,40 +62,87 @@ func init() {
 		if err != nil {
 			return nil, err
 		}
-		b, err := i2creg.Open("1")
-		if err != nil {
-			return nil, err
-		}
-		c := &i2c.Dev{
-			Addr: 0x08,
-			Bus:  b,
-		}
 		return &Moisture{
-			conn: c,
+			buses: make(map[string]i2c.BusCloser),
 		}, nil
 	})
 }
 
+// calibrate maps a reading onto 0-100% between the dry and wet points
+func calibrate(v, dry, wet float64) float64 {
+	return math.Max(0, math.Min(100, (v-dry)/(wet-dry)*100))
+}
+
+// openBus returns the named bus, opening it if necessary
+func (m *Moisture) openBus(name string) (i2c.Bus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if b, ok := m.buses[name]; ok {
+		return b, nil
+	}
+	b, err := i2creg.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	m.buses[name] = b
+	return b, nil
+}
+
 func (m *Moisture) ReadInit(node *yaml.Node) (any, error) {
-	params := &inputParams{}
+	params := &inputParams{
+		Bus:      defaultBus,
+		Address:  defaultAddress,
+		Register: registerVoltage,
+	}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
+	if params.Channel < 0 || params.Channel >= numChannels {
+		return nil, errors.New("channel must be between 0 and 7")
+	}
+	reg, ok := registers[params.Register]
+	if !ok {
+		return nil, errors.New("register must be \"raw\", \"voltage\" or \"ratio\"")
+	}
+	if (params.Dry == nil) != (params.Wet == nil) {
+		return nil, errors.New("both dry and wet must be specified for calibration")
+	}
+	if params.Dry != nil && *params.Dry == *params.Wet {
+		return nil, errors.New("dry and wet must be different")
+	}
+	b, err := m.openBus(params.Bus)
+	if err != nil {
+		return nil, err
+	}
 	return &inputData{
-		W: []byte{byte(0x20 + params.Channel)},
-		R: make([]byte, 2),
+		dev: &i2c.Dev{
+			Addr: params.Address,
+			Bus:  b,
+		},
+		W:   []byte{reg + byte(params.Channel)},
+		R:   make([]byte, 2),
+		dry: params.Dry,
+		wet: params.Wet,
 	}, nil
 }
 
 func (m *Moisture) Read(data any) (float64, error) {
 	d := data.(*inputData)
-	if err := m.conn.Tx(d.W, d.R); err != nil {
+	if err := d.dev.Tx(d.W, d.R); err != nil {
 		return 0, err
 	}
-	v := binary.LittleEndian.Uint16(d.R)
-	return float64(v), nil
+	v := float64(binary.LittleEndian.Uint16(d.R))
+	if d.dry != nil {
+		return calibrate(v, *d.dry, *d.wet), nil
+	}
+	return v, nil
 }
 
 func (m *Moisture) ReadClose(any) {}
 
-func (m *Moisture) Close() {}
+// Close closes the I2C buses.
+func (m *Moisture) Close() {
+	for _, b := range m.buses {
+		b.Close()
+	}
+}
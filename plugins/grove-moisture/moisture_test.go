@@ -11,3 +11,20 @@ func TestPlugin(t *testing.T) {
 		t.Fatal("Moisture does not correctly implement InputPlugin")
 	}
 }
+
+func TestCalibrate(t *testing.T) {
+	for _, v := range []struct {
+		value  float64
+		result float64
+	}{
+		{2000, 0},
+		{2500, 0},
+		{1500, 50},
+		{1000, 100},
+		{800, 100},
+	} {
+		if r := calibrate(v.value, 2000, 1000); r != v.result {
+			t.Fatalf("%f: %f != %f", v.value, r, v.result)
+		}
+	}
+}
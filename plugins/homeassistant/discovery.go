@@ -0,0 +1,204 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nathan-osman/sensorpi/mqttclient"
+	"github.com/rs/zerolog/log"
+)
+
+// entityList keeps track of the discovery topics published in this run and
+// the previous one (persisted to disk) so that entities removed from the
+// config can also be removed from Home Assistant
+type entityList struct {
+	mutex    sync.Mutex
+	filename string
+	previous map[string]bool
+	current  map[string][]byte
+}
+
+// stateMessage is the last state published to a topic, kept so that it can
+// be published again if Home Assistant or the broker lose retained messages
+type stateMessage struct {
+	payload string
+	retain  bool
+}
+
+func newEntityList(filename string) *entityList {
+	e := &entityList{
+		filename: filename,
+		previous: make(map[string]bool),
+		current:  make(map[string][]byte),
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Msgf("homeassistant: %s", err.Error())
+		}
+		return e
+	}
+	topics := []string{}
+	if err := json.Unmarshal(b, &topics); err != nil {
+		log.Warn().Msgf("homeassistant: %s", err.Error())
+		return e
+	}
+	for _, t := range topics {
+		e.previous[t] = true
+	}
+	return e
+}
+
+func (e *entityList) save() error {
+	topics := []string{}
+	for t := range e.current {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	b, err := json.Marshal(topics)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(e.filename, b, 0644)
+}
+
+// Add records a discovery payload that was published.
+func (e *entityList) Add(topic string, payload []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.current[topic] = payload
+}
+
+// Configs returns a copy of the discovery payloads published during this run.
+func (e *entityList) Configs() map[string][]byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	configs := make(map[string][]byte)
+	for t, b := range e.current {
+		configs[t] = b
+	}
+	return configs
+}
+
+// Stale returns the topics that were published previously but not during
+// this run and persists the current list.
+func (e *entityList) Stale() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	stale := []string{}
+	for t := range e.previous {
+		if _, ok := e.current[t]; !ok {
+			stale = append(stale, t)
+		}
+	}
+	e.previous = make(map[string]bool)
+	for t := range e.current {
+		e.previous[t] = true
+	}
+	if err := e.save(); err != nil {
+		log.Warn().Msgf("homeassistant: %s", err.Error())
+	}
+	return stale
+}
+
+// publishConfig publishes a retained discovery payload
+func (h *HomeAssistant) publishConfig(topic string, payload map[string]any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if t := h.client.Publish(topic, 0, true, b); t.Wait() && t.Error() != nil {
+		return t.Error()
+	}
+	h.entities.Add(topic, b)
+	return nil
+}
+
+// recordState remembers the last state published to a topic
+func (h *HomeAssistant) recordState(topic, payload string, retain bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastStates[topic] = &stateMessage{
+		payload: payload,
+		retain:  retain,
+	}
+}
+
+// publishState publishes the state of an entity, remembering it so that it
+// can be announced again later
+func (h *HomeAssistant) publishState(topic, payload string, retain bool) error {
+	h.recordState(topic, payload, retain)
+	if t := h.client.Publish(topic, 0, retain, payload); t.Wait() && t.Error() != nil {
+		return t.Error()
+	}
+	return nil
+}
+
+// announce publishes all of the discovery payloads and latest states again
+func (h *HomeAssistant) announce(c mqtt.Client) {
+	for topic, b := range h.entities.Configs() {
+		if t := c.Publish(topic, 0, true, b); t.Wait() && t.Error() != nil {
+			log.Warn().Msgf("mqtt: %s", t.Error())
+		}
+	}
+	h.mutex.Lock()
+	states := make(map[string]*stateMessage)
+	for topic, s := range h.lastStates {
+		states[topic] = s
+	}
+	h.mutex.Unlock()
+	for topic, s := range states {
+		if t := c.Publish(topic, 0, s.retain, s.payload); t.Wait() && t.Error() != nil {
+			log.Warn().Msgf("mqtt: %s", t.Error())
+		}
+	}
+}
+
+// subscribeBirth subscribes to the Home Assistant birth topic and announces
+// everything again whenever Home Assistant comes online
+func (h *HomeAssistant) subscribeBirth() error {
+	_, err := h.subs.Subscribe(
+		h.client,
+		h.birthTopic,
+		0,
+		func(payload []byte) {
+			if string(payload) == mqttclient.StatusOnline {
+				// The handler must not block, so announce in a new goroutine
+				go h.announce(h.client)
+			}
+		},
+	)
+	return err
+}
+
+// onConnect restores the birth topic and command subscriptions (they do not
+// survive a reconnect with a clean session) and announces everything again,
+// since retained messages may have been lost while disconnected
+func (h *HomeAssistant) onConnect(c mqtt.Client) {
+	h.subs.Restore(c)
+	h.announce(c)
+}
+
+// removeStale publishes an empty discovery payload for each entity that is no
+// longer configured, which causes Home Assistant to remove it
+func (h *HomeAssistant) removeStale() {
+	for _, topic := range h.entities.Stale() {
+		log.Info().Msgf("homeassistant: removing %s", topic)
+		if t := h.client.Publish(topic, 0, true, ""); t.Wait() && t.Error() != nil {
+			log.Warn().Msgf("mqtt: %s", t.Error())
+		}
+	}
+}
+
+// Ready removes entities from the previous run that are no longer configured.
+func (h *HomeAssistant) Ready() {
+	h.removeStale()
+}
@@ -2,35 +2,81 @@ package homeassistant
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"sync"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nathan-osman/sensorpi/mqttclient"
 	"github.com/nathan-osman/sensorpi/plugin"
+	"github.com/nathan-osman/sensorpi/queue"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	typeLight   = "light"
-	typeSensor  = "sensor"
-	typeTrigger = "trigger"
+	typeBinarySensor = "binary_sensor"
+	typeButton       = "button"
+	typeLight        = "light"
+	typeNumber       = "number"
+	typeSelect       = "select"
+	typeSensor       = "sensor"
+	typeState        = "state"
+	typeSwitch       = "switch"
+	typeTrigger      = "trigger"
+)
+
+const (
+	payloadOn    = "ON"
+	payloadOff   = "OFF"
+	payloadPress = "PRESS"
 )
 
 // HomeAssistant uses MQTT (with discovery) to interact with Home Assistant
 type HomeAssistant struct {
+	mutex       sync.Mutex
 	client      mqtt.Client
 	nodeId      string
 	actionTopic string
+	statusTopic string
+	birthTopic  string
+	subs        *mqttclient.Subscriptions
 	device      map[string]any
+	states      map[string]*entityState
+	entities    *entityList
+	lastStates  map[string]*stateMessage
+}
+
+type deviceParams struct {
+	Name             string `yaml:"name"`
+	Manufacturer     string `yaml:"manufacturer"`
+	Model            string `yaml:"model"`
+	SwVersion        string `yaml:"sw_version"`
+	HwVersion        string `yaml:"hw_version"`
+	SuggestedArea    string `yaml:"suggested_area"`
+	ConfigurationURL string `yaml:"configuration_url"`
 }
 
 type pluginParams struct {
-	Addr     string `yaml:"addr"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	NodeId   string `yaml:"node_id"`
+	mqttclient.Params `yaml:",inline"`
+	NodeId            string       `yaml:"node_id"`
+	EntitiesFile      string       `yaml:"entities_file"`
+	BirthTopic        string       `yaml:"birth_topic"`
+	Device            deviceParams `yaml:"device"`
+}
+
+// entityOptions are common to every entity type
+type entityOptions struct {
+	Icon           string `yaml:"icon"`
+	EntityCategory string `yaml:"entity_category"`
+}
+
+// stateOptions are common to entity types that report a state
+type stateOptions struct {
+	ExpireAfter int  `yaml:"expire_after"`
+	ForceUpdate bool `yaml:"force_update"`
 }
 
 type outputTriggerParams struct {
@@ -39,11 +85,28 @@ type outputTriggerParams struct {
 }
 
 type outputParamsSensor struct {
+	entityOptions             `yaml:",inline"`
+	stateOptions              `yaml:",inline"`
 	ID                        string `yaml:"id"`
 	Name                      string `yaml:"name"`
 	Class                     string `yaml:"class"`
 	UnitOfMeasurement         string `yaml:"unit_of_measurement"`
 	SuggestedDisplayPrecision string `yaml:"suggested_display_precision"`
+	StateClass                string `yaml:"state_class"`
+}
+
+type outputParamsBinarySensor struct {
+	entityOptions `yaml:",inline"`
+	stateOptions  `yaml:",inline"`
+	ID            string `yaml:"id"`
+	Name          string `yaml:"name"`
+	Class         string `yaml:"class"`
+	OffDelay      int    `yaml:"off_delay"`
+	Retain        bool   `yaml:"retain"`
+}
+
+type outputParamsState struct {
+	ID string `yaml:"id"`
 }
 
 type outputParamsTrigger struct {
@@ -59,13 +122,54 @@ type outputDataSensor struct {
 	topic string
 }
 
+type outputDataBinarySensor struct {
+	topic  string
+	retain bool
+}
+
+type outputDataState struct {
+	id string
+}
+
 type outputDataTrigger struct {
 	subtype string
 }
 
+type triggerParamsEntity struct {
+	queue.Params  `yaml:",inline"`
+	entityOptions `yaml:",inline"`
+	ID            string `yaml:"id"`
+	Name          string `yaml:"name"`
+	Class         string `yaml:"class"`
+
+	// Commands are echoed to the state topic unless a "state" output reports
+	// the actual state instead
+	StateFeedback bool `yaml:"state_feedback"`
+}
+
 type triggerParamsLight struct {
-	ID   string `yaml:"id"`
-	Name string `yaml:"name"`
+	triggerParamsEntity `yaml:",inline"`
+	Brightness          bool `yaml:"brightness"`
+	Optimistic          bool `yaml:"optimistic"`
+}
+
+type triggerParamsNumber struct {
+	triggerParamsEntity `yaml:",inline"`
+	Min                 float64 `yaml:"min"`
+	Max                 float64 `yaml:"max"`
+	Step                float64 `yaml:"step"`
+	Mode                string  `yaml:"mode"`
+	UnitOfMeasurement   string  `yaml:"unit_of_measurement"`
+}
+
+type triggerParamsSelectOption struct {
+	Name  string  `yaml:"name"`
+	Value float64 `yaml:"value"`
+}
+
+type triggerParamsSelect struct {
+	triggerParamsEntity `yaml:",inline"`
+	Options             []*triggerParamsSelectOption `yaml:"options"`
 }
 
 type triggerData interface {
@@ -73,14 +177,17 @@ type triggerData interface {
 	Close(*HomeAssistant)
 }
 
-type triggerDataLight struct {
-	topic   string
-	cmdChan <-chan bool
+type triggerDataCommand struct {
+	queue       *queue.Queue
+	unsubscribe func()
 }
 
 func init() {
 	plugin.Register("homeassistant", func(node *yaml.Node) (plugin.Plugin, error) {
-		params := &pluginParams{}
+		params := &pluginParams{
+			Params:     mqttclient.DefaultParams,
+			BirthTopic: "homeassistant/status",
+		}
 		if err := node.Decode(params); err != nil {
 			return nil, err
 		}
@@ -91,35 +198,91 @@ func init() {
 			}
 			params.NodeId = h
 		}
-		c := mqtt.NewClient(
-			mqtt.NewClientOptions().
-				AddBroker(fmt.Sprintf("tcp://%s", params.Addr)).
-				SetClientID(params.NodeId).
-				SetResumeSubs(true).
-				SetPassword(params.Password).
-				SetUsername(params.Username),
-		)
-		if t := c.Connect(); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
+		if params.EntitiesFile == "" {
+			params.EntitiesFile = fmt.Sprintf(
+				"/var/lib/sensorpi/homeassistant_%s.json",
+				params.NodeId,
+			)
+		}
+		opts, err := params.ClientOptions(params.NodeId)
+		if err != nil {
+			return nil, err
+		}
+		statusTopic := params.StatusTopic
+		if statusTopic == "" {
+			statusTopic = fmt.Sprintf("sensorpi/%s/status", params.NodeId)
 		}
 		h := &HomeAssistant{
-			client: c,
 			nodeId: params.NodeId,
 			actionTopic: fmt.Sprintf(
 				"sensorpi/%s/action",
 				params.NodeId,
 			),
-			device: map[string]any{
-				"identifiers": []string{
-					fmt.Sprintf("sensorpi_%s", params.NodeId),
-				},
-				"name": params.NodeId,
-			},
+			statusTopic: statusTopic,
+			device:      params.Device.payload(params.NodeId),
+			states:      make(map[string]*entityState),
+			birthTopic:  params.BirthTopic,
+			subs:        mqttclient.NewSubscriptions(),
+			entities:    newEntityList(params.EntitiesFile),
+			lastStates:  make(map[string]*stateMessage),
+		}
+		mqttclient.SetAvailability(opts, statusTopic, h.onConnect)
+		c, err := mqttclient.Connect(opts)
+		if err != nil {
+			return nil, err
+		}
+		h.client = c
+		if err := h.subscribeBirth(); err != nil {
+			mqttclient.Disconnect(c, statusTopic)
+			return nil, err
 		}
 		return h, nil
 	})
 }
 
+func (d *deviceParams) payload(nodeId string) map[string]any {
+	p := map[string]any{
+		"identifiers": []string{
+			fmt.Sprintf("sensorpi_%s", nodeId),
+		},
+		"name": nodeId,
+	}
+	if d.Name != "" {
+		p["name"] = d.Name
+	}
+	for k, v := range map[string]string{
+		"manufacturer":      d.Manufacturer,
+		"model":             d.Model,
+		"sw_version":        d.SwVersion,
+		"hw_version":        d.HwVersion,
+		"suggested_area":    d.SuggestedArea,
+		"configuration_url": d.ConfigurationURL,
+	} {
+		if v != "" {
+			p[k] = v
+		}
+	}
+	return p
+}
+
+func (o *entityOptions) apply(payload map[string]any) {
+	if o.Icon != "" {
+		payload["icon"] = o.Icon
+	}
+	if o.EntityCategory != "" {
+		payload["entity_category"] = o.EntityCategory
+	}
+}
+
+func (o *stateOptions) apply(payload map[string]any) {
+	if o.ExpireAfter != 0 {
+		payload["expire_after"] = o.ExpireAfter
+	}
+	if o.ForceUpdate {
+		payload["force_update"] = true
+	}
+}
+
 func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 	params := &outputTriggerParams{}
 	if err := node.Decode(params); err != nil {
@@ -143,12 +306,13 @@ func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 				cParams.ID,
 			)
 			payload = map[string]any{
-				"platform":     "sensor",
-				"unique_id":    cParams.ID,
-				"name":         cParams.Name,
-				"device_class": cParams.Class,
-				"state_topic":  stateTopic,
-				"device":       h.device,
+				"platform":           "sensor",
+				"unique_id":          cParams.ID,
+				"name":               cParams.Name,
+				"device_class":       cParams.Class,
+				"state_topic":        stateTopic,
+				"availability_topic": h.statusTopic,
+				"device":             h.device,
 			}
 		)
 		if cParams.UnitOfMeasurement != "" {
@@ -157,16 +321,69 @@ func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 		if cParams.SuggestedDisplayPrecision != "" {
 			payload["suggested_display_precision"] = cParams.SuggestedDisplayPrecision
 		}
-		b, err := json.Marshal(payload)
-		if err != nil {
-			return nil, err
+		if cParams.StateClass != "" {
+			payload["state_class"] = cParams.StateClass
 		}
-		if t := h.client.Publish(topic, 0, true, b); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
+		cParams.entityOptions.apply(payload)
+		cParams.stateOptions.apply(payload)
+		if err := h.publishConfig(topic, payload); err != nil {
+			return nil, err
 		}
 		return &outputDataSensor{
 			topic: stateTopic,
 		}, nil
+	case typeBinarySensor:
+		cParams := &outputParamsBinarySensor{
+			Retain: true,
+		}
+		if err := params.Parameters.Decode(cParams); err != nil {
+			return nil, err
+		}
+		var (
+			topic = fmt.Sprintf(
+				"homeassistant/binary_sensor/%s/%s/config",
+				h.nodeId,
+				cParams.ID,
+			)
+			stateTopic = fmt.Sprintf(
+				"sensorpi/%s/%s/state",
+				h.nodeId,
+				cParams.ID,
+			)
+			payload = map[string]any{
+				"platform":           "binary_sensor",
+				"unique_id":          cParams.ID,
+				"name":               cParams.Name,
+				"state_topic":        stateTopic,
+				"payload_on":         payloadOn,
+				"payload_off":        payloadOff,
+				"availability_topic": h.statusTopic,
+				"device":             h.device,
+			}
+		)
+		if cParams.Class != "" {
+			payload["device_class"] = cParams.Class
+		}
+		if cParams.OffDelay != 0 {
+			payload["off_delay"] = cParams.OffDelay
+		}
+		cParams.entityOptions.apply(payload)
+		cParams.stateOptions.apply(payload)
+		if err := h.publishConfig(topic, payload); err != nil {
+			return nil, err
+		}
+		return &outputDataBinarySensor{
+			topic:  stateTopic,
+			retain: cParams.Retain,
+		}, nil
+	case typeState:
+		cParams := &outputParamsState{}
+		if err := params.Parameters.Decode(cParams); err != nil {
+			return nil, err
+		}
+		return &outputDataState{
+			id: cParams.ID,
+		}, nil
 	case typeTrigger:
 		cParams := &outputParamsTrigger{}
 		if err := params.Parameters.Decode(cParams); err != nil {
@@ -175,6 +392,7 @@ func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 		if cParams.Type == "" {
 			cParams.Type = "action"
 		}
+		// Device triggers have no state and therefore no availability
 		var (
 			topic = fmt.Sprintf(
 				"homeassistant/device_automation/%s/%s_%s/config",
@@ -191,13 +409,9 @@ func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 				"device":          h.device,
 			}
 		)
-		b, err := json.Marshal(payload)
-		if err != nil {
+		if err := h.publishConfig(topic, payload); err != nil {
 			return nil, err
 		}
-		if t := h.client.Publish(topic, 0, true, b); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
-		}
 		return &outputDataTrigger{
 			subtype: cParams.Subtype,
 		}, nil
@@ -207,15 +421,25 @@ func (h *HomeAssistant) WriteInit(node *yaml.Node) (any, error) {
 }
 
 func (o *outputDataSensor) Write(h *HomeAssistant, v float64) error {
-	if t := h.client.Publish(
-		o.topic,
-		0,
-		true,
-		fmt.Sprintf("%f", v),
-	); t.Wait() && t.Error() != nil {
-		return t.Error()
+	return h.publishState(o.topic, fmt.Sprintf("%f", v), true)
+}
+
+func (o *outputDataBinarySensor) Write(h *HomeAssistant, v float64) error {
+	payload := payloadOff
+	if v != 0 {
+		payload = payloadOn
 	}
-	return nil
+	return h.publishState(o.topic, payload, o.retain)
+}
+
+func (o *outputDataState) Write(h *HomeAssistant, v float64) error {
+	h.mutex.Lock()
+	e := h.states[o.id]
+	h.mutex.Unlock()
+	if e == nil {
+		return fmt.Errorf("no entity with ID \"%s\" has a state", o.id)
+	}
+	return e.Publish(h, v)
 }
 
 func (o *outputDataTrigger) Write(h *HomeAssistant, v float64) error {
@@ -237,90 +461,268 @@ func (h *HomeAssistant) Write(data any, v float64) error {
 	return data.(outputData).Write(h, v)
 }
 
-func (h *HomeAssistant) WriteClose(data any) {}
+func (h *HomeAssistant) WriteClose(any) {}
+
+// Dependent returns true for "state" outputs, which should only report a
+// value once the outputs before them have applied it.
+func (h *HomeAssistant) Dependent(data any) bool {
+	_, ok := data.(*outputDataState)
+	return ok
+}
+
+// subscribeCommand subscribes to the command topic for an entity, using
+// convert to turn each payload into a value; if stateTopic is set, accepted
+// payloads are echoed back to it so that Home Assistant reflects the change
+func (h *HomeAssistant) subscribeCommand(
+	commandTopic string,
+	stateTopic string,
+	queueParams *queue.Params,
+	convert func(string) (float64, bool),
+) (*triggerDataCommand, error) {
+	q, err := queue.New(queueParams)
+	if err != nil {
+		return nil, err
+	}
+	unsubscribe, err := h.subs.Subscribe(
+		h.client,
+		commandTopic,
+		0,
+		func(payload []byte) {
+			p := string(payload)
+			v, ok := convert(p)
+			if !ok {
+				log.Warn().Msgf("mqtt: unexpected payload \"%s\"", p)
+				return
+			}
+			q.Push(v)
+			if stateTopic != "" {
+				// The handler must not block, so don't wait for the token
+				h.recordState(stateTopic, p, true)
+				h.client.Publish(stateTopic, 0, true, p)
+			}
+		},
+	)
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	return &triggerDataCommand{
+		queue:       q,
+		unsubscribe: unsubscribe,
+	}, nil
+}
+
+func convertOnOff(p string) (float64, bool) {
+	switch p {
+	case payloadOn:
+		return 1, true
+	case payloadOff:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
 
 func (h *HomeAssistant) WatchInit(node *yaml.Node) (any, error) {
 	params := &outputTriggerParams{}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
+	var (
+		cParams = &triggerParamsEntity{}
+		convert func(string) (float64, bool)
+		format  func(float64) (string, error)
+		echo    bool
+		payload = map[string]any{}
+	)
 	switch params.Type {
 	case typeLight:
-		cParams := &triggerParamsLight{}
+		lParams := &triggerParamsLight{}
+		if err := params.Parameters.Decode(lParams); err != nil {
+			return nil, err
+		}
+		cParams = &lParams.triggerParamsEntity
+		convert = convertOnOff
+		format = formatOnOff
+		// Without a "state" output reporting the state, commands are echoed
+		// so that the light behaves as it did before it had a state topic
+		echo = !cParams.StateFeedback
+		if lParams.Brightness {
+			l := &lightBrightness{
+				last: 100,
+			}
+			convert = l.Convert
+			format = formatBrightness
+			payload["schema"] = "json"
+			payload["brightness"] = true
+			payload["brightness_scale"] = 100
+			payload["supported_color_modes"] = []string{"brightness"}
+		}
+		if lParams.Optimistic {
+			echo = true
+			payload["optimistic"] = true
+		}
+	case typeSwitch:
 		if err := params.Parameters.Decode(cParams); err != nil {
 			return nil, err
 		}
-		var (
-			topic = fmt.Sprintf(
-				"homeassistant/light/%s/%s/config",
-				h.nodeId,
-				cParams.ID,
-			)
-			commandTopic = fmt.Sprintf(
-				"sensorpi/%s/%s/switch",
-				h.nodeId,
-				cParams.ID,
-			)
-			payload = map[string]any{
-				"platform":      "light",
-				"unique_id":     cParams.ID,
-				"name":          cParams.Name,
-				"command_topic": commandTopic,
-				"device":        h.device,
+		convert = convertOnOff
+		format = formatOnOff
+		echo = !cParams.StateFeedback
+		payload["payload_on"] = payloadOn
+		payload["payload_off"] = payloadOff
+		payload["state_on"] = payloadOn
+		payload["state_off"] = payloadOff
+		if cParams.Class != "" {
+			payload["device_class"] = cParams.Class
+		}
+	case typeNumber:
+		nParams := &triggerParamsNumber{
+			Min:  1,
+			Max:  100,
+			Step: 1,
+		}
+		if err := params.Parameters.Decode(nParams); err != nil {
+			return nil, err
+		}
+		cParams = &nParams.triggerParamsEntity
+		convert = func(p string) (float64, bool) {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v < nParams.Min || v > nParams.Max {
+				return 0, false
 			}
-		)
-		b, err := json.Marshal(payload)
-		if err != nil {
+			return v, true
+		}
+		format = func(v float64) (string, error) {
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		echo = !cParams.StateFeedback
+		payload["min"] = nParams.Min
+		payload["max"] = nParams.Max
+		payload["step"] = nParams.Step
+		if nParams.Mode != "" {
+			payload["mode"] = nParams.Mode
+		}
+		if nParams.UnitOfMeasurement != "" {
+			payload["unit_of_measurement"] = nParams.UnitOfMeasurement
+		}
+		if cParams.Class != "" {
+			payload["device_class"] = cParams.Class
+		}
+	case typeSelect:
+		sParams := &triggerParamsSelect{}
+		if err := params.Parameters.Decode(sParams); err != nil {
 			return nil, err
 		}
-		if t := h.client.Publish(topic, 0, true, b); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
-		}
-		cmdChan := make(chan bool)
-		if t := h.client.Subscribe(
-			commandTopic,
-			0,
-			func(client mqtt.Client, msg mqtt.Message) {
-				switch string(msg.Payload()) {
-				case "ON":
-					cmdChan <- true
-				case "OFF":
-					cmdChan <- false
+		if len(sParams.Options) == 0 {
+			return nil, errors.New("select requires at least one option")
+		}
+		cParams = &sParams.triggerParamsEntity
+		var (
+			options = []string{}
+			values  = map[string]float64{}
+		)
+		for _, o := range sParams.Options {
+			options = append(options, o.Name)
+			values[o.Name] = o.Value
+		}
+		convert = func(p string) (float64, bool) {
+			v, ok := values[p]
+			return v, ok
+		}
+		format = func(v float64) (string, error) {
+			for _, o := range sParams.Options {
+				if o.Value == v {
+					return o.Name, nil
 				}
-			},
-		); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
+			}
+			return "", fmt.Errorf("no option has the value %f", v)
+		}
+		echo = !cParams.StateFeedback
+		payload["options"] = options
+	case typeButton:
+		if err := params.Parameters.Decode(cParams); err != nil {
+			return nil, err
+		}
+		convert = func(p string) (float64, bool) {
+			return 1, p == payloadPress
+		}
+		payload["payload_press"] = payloadPress
+		if cParams.Class != "" {
+			payload["device_class"] = cParams.Class
 		}
-		return &triggerDataLight{
-			topic:   commandTopic,
-			cmdChan: cmdChan,
-		}, nil
 	default:
 		return nil, fmt.Errorf("unrecognized type \"%s\"", params.Type)
 	}
-}
-
-func (tr *triggerDataLight) Watch(h *HomeAssistant, ctx context.Context) (float64, error) {
-	select {
-	case v := <-tr.cmdChan:
-		if v {
-			return 1, nil
-		} else {
-			return 0, nil
+	var (
+		topic = fmt.Sprintf(
+			"homeassistant/%s/%s/%s/config",
+			params.Type,
+			h.nodeId,
+			cParams.ID,
+		)
+		commandTopic = fmt.Sprintf(
+			"sensorpi/%s/%s/set",
+			h.nodeId,
+			cParams.ID,
+		)
+		stateTopic string
+		echoTopic  string
+	)
+	if params.Type == typeLight {
+		// Lights have always used this topic, so keep it for compatibility
+		commandTopic = fmt.Sprintf(
+			"sensorpi/%s/%s/switch",
+			h.nodeId,
+			cParams.ID,
+		)
+	}
+	if format != nil {
+		stateTopic = fmt.Sprintf(
+			"sensorpi/%s/%s/state",
+			h.nodeId,
+			cParams.ID,
+		)
+		payload["state_topic"] = stateTopic
+		h.mutex.Lock()
+		h.states[cParams.ID] = &entityState{
+			topic:  stateTopic,
+			format: format,
+		}
+		h.mutex.Unlock()
+		if echo {
+			echoTopic = stateTopic
 		}
-	case <-ctx.Done():
-		return 0, context.Canceled
 	}
+	cParams.entityOptions.apply(payload)
+	payload["platform"] = params.Type
+	payload["unique_id"] = cParams.ID
+	payload["name"] = cParams.Name
+	payload["command_topic"] = commandTopic
+	payload["availability_topic"] = h.statusTopic
+	payload["device"] = h.device
+	if err := h.publishConfig(topic, payload); err != nil {
+		return nil, err
+	}
+	return h.subscribeCommand(
+		commandTopic,
+		echoTopic,
+		&cParams.Params,
+		convert,
+	)
+}
+
+func (tr *triggerDataCommand) Watch(h *HomeAssistant, ctx context.Context) (float64, error) {
+	return tr.queue.Pop(ctx)
 }
 
 func (h *HomeAssistant) Watch(data any, ctx context.Context) (float64, error) {
 	return data.(triggerData).Watch(h, ctx)
 }
 
-func (tr *triggerDataLight) Close(h *HomeAssistant) {
-	if t := h.client.Unsubscribe(tr.topic); t.Wait() && t.Error() != nil {
-		log.Warn().Msgf("mqtt: %s", t.Error())
-	}
+func (tr *triggerDataCommand) Close(h *HomeAssistant) {
+	tr.unsubscribe()
+	tr.queue.Close()
 }
 
 func (h *HomeAssistant) WatchClose(data any) {
@@ -328,5 +730,5 @@ func (h *HomeAssistant) WatchClose(data any) {
 }
 
 func (h *HomeAssistant) Close() {
-	h.client.Disconnect(1000)
+	mqttclient.Disconnect(h.client, h.statusTopic)
 }
@@ -1,9 +1,17 @@
 package homeassistant
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/nathan-osman/sensorpi/mqttclient"
+	"github.com/nathan-osman/sensorpi/mqttclient/mqttclienttest"
 	"github.com/nathan-osman/sensorpi/plugin"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPlugin(t *testing.T) {
@@ -13,4 +21,189 @@ func TestPlugin(t *testing.T) {
 	if !plugin.IsTriggerPlugin(&HomeAssistant{}) {
 		t.Fatal("HomeAssistant does not correctly implement TriggerPlugin")
 	}
+	if !plugin.IsReadyPlugin(&HomeAssistant{}) {
+		t.Fatal("HomeAssistant does not correctly implement ReadyPlugin")
+	}
+	if !plugin.IsDependentPlugin(&HomeAssistant{}) {
+		t.Fatal("HomeAssistant does not correctly implement DependentPlugin")
+	}
+}
+
+func TestDependent(t *testing.T) {
+	h := &HomeAssistant{}
+	if !h.Dependent(&outputDataState{}) {
+		t.Fatal("state output is not dependent")
+	}
+	if h.Dependent(&outputDataSensor{}) {
+		t.Fatal("sensor output is dependent")
+	}
+}
+
+func TestLightBrightness(t *testing.T) {
+	l := &lightBrightness{last: 100}
+	for _, v := range []struct {
+		payload string
+		value   float64
+	}{
+		{`{"state":"ON"}`, 100},
+		{`{"state":"ON","brightness":40}`, 40},
+		{`{"state":"OFF"}`, 0},
+		{`{"state":"ON"}`, 40},
+	} {
+		f, ok := l.Convert(v.payload)
+		if !ok {
+			t.Fatalf("%s was not accepted", v.payload)
+		}
+		if f != v.value {
+			t.Fatalf("%f != %f", f, v.value)
+		}
+	}
+	p, err := formatBrightness(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != `{"state":"ON","brightness":40}` {
+		t.Fatalf("unexpected payload %s", p)
+	}
+}
+
+func TestEntityList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "entities.json")
+	e := newEntityList(filename)
+	e.Add("a", nil)
+	e.Add("b", nil)
+	if s := e.Stale(); len(s) != 0 {
+		t.Fatalf("unexpected stale topics %v", s)
+	}
+	e = newEntityList(filename)
+	e.Add("b", nil)
+	if s := e.Stale(); len(s) != 1 || s[0] != "a" {
+		t.Fatalf("unexpected stale topics %v", s)
+	}
+}
+
+func TestWatchInit(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		typ      string
+		params   string
+		keys     []string
+		absent   []string
+		commands []string
+		values   []float64
+		echo     string
+	}{
+		{
+			name:     "switch",
+			typ:      "switch",
+			params:   "  id: e\n",
+			keys:     []string{"payload_on", "payload_off", "state_on", "state_off", "state_topic"},
+			commands: []string{"ON", "on", "OFF"},
+			values:   []float64{1, 0},
+			echo:     "OFF",
+		},
+		{
+			name:     "switch with state feedback",
+			typ:      "switch",
+			params:   "  id: e\n  state_feedback: true\n",
+			keys:     []string{"state_topic"},
+			commands: []string{"ON"},
+			values:   []float64{1},
+		},
+		{
+			name:     "number",
+			typ:      "number",
+			params:   "  id: e\n  min: 10\n  max: 20\n",
+			keys:     []string{"min", "max", "step", "state_topic"},
+			commands: []string{"15", "5", "21", "abc", "20"},
+			values:   []float64{15, 20},
+			echo:     "20",
+		},
+		{
+			name:     "select",
+			typ:      "select",
+			params:   "  id: e\n  options:\n    - {name: a, value: 1}\n    - {name: b, value: 2}\n",
+			keys:     []string{"options", "state_topic"},
+			commands: []string{"b", "c", "a"},
+			values:   []float64{2, 1},
+			echo:     "a",
+		},
+		{
+			name:     "button",
+			typ:      "button",
+			params:   "  id: e\n",
+			keys:     []string{"payload_press"},
+			absent:   []string{"state_topic"},
+			commands: []string{"PRESS", "press"},
+			values:   []float64{1},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			var (
+				c = mqttclienttest.NewClient()
+				h = &HomeAssistant{
+					client:      c,
+					nodeId:      "node",
+					statusTopic: "sensorpi/node/status",
+					subs:        mqttclient.NewSubscriptions(),
+					states:      make(map[string]*entityState),
+					entities:    newEntityList(filepath.Join(t.TempDir(), "entities.json")),
+					lastStates:  make(map[string]*stateMessage),
+				}
+				node = &yaml.Node{}
+			)
+			config := fmt.Sprintf("type: %s\nparameters:\n%s", v.typ, v.params)
+			if err := yaml.Unmarshal([]byte(config), node); err != nil {
+				t.Fatal(err)
+			}
+			d, err := h.WatchInit(node.Content[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.WatchClose(d)
+
+			// Check the discovery payload
+			var (
+				topic   = fmt.Sprintf("homeassistant/%s/node/e/config", v.typ)
+				payload = map[string]any{}
+			)
+			if err := json.Unmarshal([]byte(c.Published(topic)), &payload); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range append(v.keys, "command_topic", "availability_topic", "unique_id") {
+				if _, ok := payload[k]; !ok {
+					t.Fatalf("%s missing from discovery payload", k)
+				}
+			}
+			for _, k := range v.absent {
+				if _, ok := payload[k]; ok {
+					t.Fatalf("unexpected %s in discovery payload", k)
+				}
+			}
+
+			// Send the commands and check which were accepted
+			for _, p := range v.commands {
+				c.Deliver("sensorpi/node/e/set", p)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for _, want := range v.values {
+				f, err := h.Watch(d, ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f != want {
+					t.Fatalf("%f != %f", f, want)
+				}
+			}
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if f, err := h.Watch(d, ctx); err == nil {
+				t.Fatalf("unexpected value %f", f)
+			}
+			if e := c.Published("sensorpi/node/e/state"); e != v.echo {
+				t.Fatalf("%s != %s", e, v.echo)
+			}
+		})
+	}
 }
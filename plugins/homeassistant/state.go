@@ -0,0 +1,77 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// entityState publishes the state of an entity that accepts commands; the
+// state is written by a "state" output, which receives the same value as the
+// other outputs of the input or trigger. The "state" output is skipped if an
+// output listed before it failed, so the state is only published once the
+// value has been applied.
+type entityState struct {
+	topic  string
+	format func(float64) (string, error)
+}
+
+type lightPayload struct {
+	State      string   `json:"state"`
+	Brightness *float64 `json:"brightness,omitempty"`
+}
+
+// lightBrightness converts JSON schema commands into a brightness between 0
+// and 100, remembering the last brightness for commands that only turn the
+// light on
+type lightBrightness struct {
+	last float64
+}
+
+func (e *entityState) Publish(h *HomeAssistant, v float64) error {
+	p, err := e.format(v)
+	if err != nil {
+		return err
+	}
+	return h.publishState(e.topic, p, true)
+}
+
+func formatOnOff(v float64) (string, error) {
+	if v != 0 {
+		return payloadOn, nil
+	}
+	return payloadOff, nil
+}
+
+func formatBrightness(v float64) (string, error) {
+	p := &lightPayload{
+		State: payloadOff,
+	}
+	if v != 0 {
+		v = math.Max(0, math.Min(100, v))
+		p.State = payloadOn
+		p.Brightness = &v
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (l *lightBrightness) Convert(payload string) (float64, bool) {
+	p := &lightPayload{}
+	if err := json.Unmarshal([]byte(payload), p); err != nil {
+		return 0, false
+	}
+	switch p.State {
+	case payloadOn:
+		if p.Brightness != nil {
+			l.last = math.Max(0, math.Min(100, *p.Brightness))
+		}
+		return l.last, true
+	case payloadOff:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -3,65 +3,181 @@ package mqtt
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"sync"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nathan-osman/sensorpi/mqttclient"
 	"github.com/nathan-osman/sensorpi/plugin"
+	"github.com/nathan-osman/sensorpi/queue"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 // Mqtt maintains a connection to an MQTT server
 type Mqtt struct {
-	client mqtt.Client
+	mutex       sync.Mutex
+	client      mqtt.Client
+	statusTopic string
+	subs        *mqttclient.Subscriptions
+	cache       map[string]*topicCache
 }
 
 type pluginParams struct {
-	Addr     string `yaml:"addr"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	mqttclient.Params `yaml:",inline"`
+}
+
+type inputParams struct {
+	extractParams `yaml:",inline"`
+	Topic         string        `yaml:"topic"`
+	Qos           uint8         `yaml:"qos"`
+	MaxAge        time.Duration `yaml:"max_age"`
+}
+
+// topicCache stores the last message received on a topic; multiple inputs
+// may share a topic (with different JSON paths, for example) so the raw
+// payload is stored and parsed when read
+type topicCache struct {
+	payload     []byte
+	received    time.Time
+	refs        int
+	unsubscribe func()
 }
 
 type outputParams struct {
-	Topic  string `yaml:"topic"`
-	Qos    uint8  `yaml:"qos"`
-	Retain bool   `yaml:"retain"`
+	payloadParams `yaml:",inline"`
+	Topic         string `yaml:"topic"`
+	Qos           uint8  `yaml:"qos"`
+	Retain        bool   `yaml:"retain"`
+}
+
+type outputData struct {
+	params    *outputParams
+	formatter *payloadFormatter
 }
 
 type triggerParams struct {
-	Topic string `yaml:"topic"`
-	Qos   uint8  `yaml:"qos"`
+	extractParams `yaml:",inline"`
+	queue.Params  `yaml:",inline"`
+	Topic         string `yaml:"topic"`
+	Qos           uint8  `yaml:"qos"`
 }
 
 type triggerData struct {
-	Topic     string
-	FloatChan <-chan float64
+	Queue       *queue.Queue
+	unsubscribe func()
 }
 
 func init() {
 	plugin.Register("mqtt", func(node *yaml.Node) (plugin.Plugin, error) {
-		params := &pluginParams{}
+		params := &pluginParams{
+			Params: mqttclient.DefaultParams,
+		}
 		if err := node.Decode(params); err != nil {
 			return nil, err
 		}
-		c := mqtt.NewClient(
-			mqtt.NewClientOptions().
-				AddBroker(fmt.Sprintf("tcp://%s", params.Addr)).
-				SetClientID("sensorpi").
-				SetResumeSubs(true).
-				SetPassword(params.Password).
-				SetUsername(params.Username),
-		)
-		if t := c.Connect(); t.Wait() && t.Error() != nil {
-			return nil, t.Error()
+		clientID, err := mqttclient.HostnameClientID()
+		if err != nil {
+			return nil, err
+		}
+		opts, err := params.ClientOptions(clientID)
+		if err != nil {
+			return nil, err
+		}
+		statusTopic := params.StatusTopic
+		if statusTopic == "" {
+			statusTopic = fmt.Sprintf("sensorpi/%s/status", opts.ClientID)
 		}
 		m := &Mqtt{
-			client: c,
+			statusTopic: statusTopic,
+			subs:        mqttclient.NewSubscriptions(),
+			cache:       make(map[string]*topicCache),
 		}
+		mqttclient.SetAvailability(opts, statusTopic, m.subs.Restore)
+		c, err := mqttclient.Connect(opts)
+		if err != nil {
+			return nil, err
+		}
+		m.client = c
 		return m, nil
 	})
 }
 
+// release removes a reference to the cached topic, returning the cache
+// entry if it was the last one
+func (m *Mqtt) release(topic string) *topicCache {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c := m.cache[topic]
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(m.cache, topic)
+	return c
+}
+
+func (m *Mqtt) ReadInit(node *yaml.Node) (any, error) {
+	params := &inputParams{}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	c, ok := m.cache[params.Topic]
+	if !ok {
+		c = &topicCache{}
+		m.cache[params.Topic] = c
+	}
+	c.refs++
+	m.mutex.Unlock()
+	if !ok {
+		unsubscribe, err := m.subs.Subscribe(
+			m.client,
+			params.Topic,
+			params.Qos,
+			func(payload []byte) {
+				m.mutex.Lock()
+				defer m.mutex.Unlock()
+				c.payload = payload
+				c.received = time.Now()
+			},
+		)
+		if err != nil {
+			m.release(params.Topic)
+			return nil, err
+		}
+		m.mutex.Lock()
+		c.unsubscribe = unsubscribe
+		m.mutex.Unlock()
+	}
+	return params, nil
+}
+
+func (m *Mqtt) Read(data any) (float64, error) {
+	params := data.(*inputParams)
+	m.mutex.Lock()
+	var (
+		c        = m.cache[params.Topic]
+		payload  = c.payload
+		received = c.received
+	)
+	m.mutex.Unlock()
+	if received.IsZero() {
+		return 0, fmt.Errorf("no value received on %s", params.Topic)
+	}
+	if params.MaxAge != 0 && time.Since(received) > params.MaxAge {
+		return 0, fmt.Errorf("value on %s is stale", params.Topic)
+	}
+	return params.Extract(payload)
+}
+
+func (m *Mqtt) ReadClose(data any) {
+	params := data.(*inputParams)
+	if c := m.release(params.Topic); c != nil && c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+}
+
 func (m *Mqtt) WriteInit(node *yaml.Node) (any, error) {
 	params := &outputParams{
 		Qos:    1,
@@ -70,16 +186,27 @@ func (m *Mqtt) WriteInit(node *yaml.Node) (any, error) {
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	return params, nil
+	f, err := newPayloadFormatter(&params.payloadParams)
+	if err != nil {
+		return nil, err
+	}
+	return &outputData{
+		params:    params,
+		formatter: f,
+	}, nil
 }
 
 func (m *Mqtt) Write(data any, v float64) error {
-	params := data.(*outputParams)
+	d := data.(*outputData)
+	b, err := d.formatter.Format(v)
+	if err != nil {
+		return err
+	}
 	if t := m.client.Publish(
-		params.Topic,
-		params.Qos,
-		params.Retain,
-		fmt.Sprintf("%f", v),
+		d.params.Topic,
+		d.params.Qos,
+		d.params.Retain,
+		b,
 	); t.Wait() && t.Error() != nil {
 		return t.Error()
 	}
@@ -93,44 +220,43 @@ func (m *Mqtt) WatchInit(node *yaml.Node) (any, error) {
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	fChan := make(chan float64)
-	if t := m.client.Subscribe(
+	q, err := queue.New(&params.Params)
+	if err != nil {
+		return nil, err
+	}
+	unsubscribe, err := m.subs.Subscribe(
+		m.client,
 		params.Topic,
 		params.Qos,
-		func(client mqtt.Client, msg mqtt.Message) {
-			v, err := strconv.ParseFloat(string(msg.Payload()), 64)
+		func(payload []byte) {
+			v, err := params.Extract(payload)
 			if err != nil {
 				log.Warn().Msgf("mqtt: %s", err.Error())
 				return
 			}
-			fChan <- v
+			q.Push(v)
 		},
-	); t.Wait() && t.Error() != nil {
-		return nil, t.Error()
+	)
+	if err != nil {
+		q.Close()
+		return nil, err
 	}
 	return &triggerData{
-		Topic:     params.Topic,
-		FloatChan: fChan,
+		Queue:       q,
+		unsubscribe: unsubscribe,
 	}, nil
 }
 
 func (m *Mqtt) Watch(data any, ctx context.Context) (float64, error) {
-	d := data.(*triggerData)
-	select {
-	case v := <-d.FloatChan:
-		return v, nil
-	case <-ctx.Done():
-		return 0, context.Canceled
-	}
+	return data.(*triggerData).Queue.Pop(ctx)
 }
 
 func (m *Mqtt) WatchClose(data any) {
 	d := data.(*triggerData)
-	if t := m.client.Unsubscribe(d.Topic); t.Wait() && t.Error() != nil {
-		log.Warn().Msgf("mqtt: %s", t.Error())
-	}
+	d.unsubscribe()
+	d.Queue.Close()
 }
 
 func (m *Mqtt) Close() {
-	m.client.Disconnect(1000)
+	mqttclient.Disconnect(m.client, m.statusTopic)
 }
@@ -2,11 +2,35 @@ package mqtt
 
 import (
 	"testing"
+	"time"
 
+	"github.com/nathan-osman/sensorpi/mqttclient"
+	"github.com/nathan-osman/sensorpi/mqttclient/mqttclienttest"
 	"github.com/nathan-osman/sensorpi/plugin"
+	"gopkg.in/yaml.v3"
 )
 
+func newTestMqtt() (*Mqtt, *mqttclienttest.Client) {
+	c := mqttclienttest.NewClient()
+	return &Mqtt{
+		client: c,
+		subs:   mqttclient.NewSubscriptions(),
+		cache:  make(map[string]*topicCache),
+	}, c
+}
+
+func decode(t *testing.T, v string) *yaml.Node {
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(v), n); err != nil {
+		t.Fatal(err)
+	}
+	return n.Content[0]
+}
+
 func TestPlugin(t *testing.T) {
+	if !plugin.IsInputPlugin(&Mqtt{}) {
+		t.Fatal("Mqtt does not correctly implement InputPlugin")
+	}
 	if !plugin.IsOutputPlugin(&Mqtt{}) {
 		t.Fatal("Mqtt does not correctly implement OutputPlugin")
 	}
@@ -14,3 +38,184 @@ func TestPlugin(t *testing.T) {
 		t.Fatal("Mqtt does not correctly implement TriggerPlugin")
 	}
 }
+
+func TestExtract(t *testing.T) {
+	for _, v := range []struct {
+		params  extractParams
+		payload string
+		value   float64
+	}{
+		{extractParams{}, "21.5", 21.5},
+		{extractParams{Values: map[string]float64{"ON": 1}}, "ON", 1},
+		{extractParams{JSONPath: "temperature"}, `{"temperature":21.4}`, 21.4},
+		{extractParams{JSONPath: "a.1.b"}, `{"a":[{},{"b":"3"}]}`, 3},
+		{
+			extractParams{
+				JSONPath: "state",
+				Values:   map[string]float64{"ON": 1, "OFF": 0},
+			},
+			`{"state":"OFF"}`,
+			0,
+		},
+	} {
+		f, err := v.params.Extract([]byte(v.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != v.value {
+			t.Fatalf("%f != %f", f, v.value)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	f, err := newPayloadFormatter(&payloadParams{
+		Template: "{{.Name}}={{.Value}}",
+		Name:     "temp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := f.Format(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "temp=2" {
+		t.Fatalf("unexpected payload %s", b)
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		params  string
+		payload string
+		age     time.Duration
+		value   float64
+		valid   bool
+	}{
+		{
+			name:   "no value received",
+			params: "topic: t",
+		},
+		{
+			name:    "value",
+			params:  "topic: t",
+			payload: "21.5",
+			value:   21.5,
+			valid:   true,
+		},
+		{
+			name:    "json path",
+			params:  "topic: t\njson_path: temperature",
+			payload: `{"temperature":19}`,
+			value:   19,
+			valid:   true,
+		},
+		{
+			name:    "old value without max_age",
+			params:  "topic: t",
+			payload: "1",
+			age:     time.Hour,
+			value:   1,
+			valid:   true,
+		},
+		{
+			name:    "fresh value",
+			params:  "topic: t\nmax_age: 1m",
+			payload: "1",
+			age:     time.Second,
+			value:   1,
+			valid:   true,
+		},
+		{
+			name:    "stale value",
+			params:  "topic: t\nmax_age: 1m",
+			payload: "1",
+			age:     time.Hour,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			m, c := newTestMqtt()
+			d, err := m.ReadInit(decode(t, v.params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.ReadClose(d)
+			if v.payload != "" {
+				c.Deliver("t", v.payload)
+
+				// Backdate the message rather than waiting for it to age
+				m.cache["t"].received = time.Now().Add(-v.age)
+			}
+			f, err := m.Read(d)
+			if !v.valid {
+				if err == nil {
+					t.Fatalf("read did not fail: %f", f)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f != v.value {
+				t.Fatalf("%f != %f", f, v.value)
+			}
+		})
+	}
+}
+
+func TestReadClose(t *testing.T) {
+	m, c := newTestMqtt()
+	a, err := m.ReadInit(decode(t, "topic: t\njson_path: a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.ReadInit(decode(t, "topic: t\njson_path: b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := m.WatchInit(decode(t, "topic: t\njson_path: a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Subscribes(); n != 1 {
+		t.Fatalf("%d subscriptions, expected 1", n)
+	}
+
+	// Both inputs share the message received on the topic
+	c.Deliver("t", `{"a":1,"b":2}`)
+	for _, v := range []struct {
+		data  any
+		value float64
+	}{
+		{a, 1},
+		{b, 2},
+	} {
+		f, err := m.Read(v.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != v.value {
+			t.Fatalf("%f != %f", f, v.value)
+		}
+	}
+
+	// The topic must remain subscribed until everything using it is closed
+	for _, release := range []func(){
+		func() { m.ReadClose(a) },
+		func() { m.ReadClose(b) },
+	} {
+		release()
+		if !c.Subscribed("t") || c.Unsubscribes() != 0 {
+			t.Fatal("unsubscribed while the topic was still in use")
+		}
+	}
+	if _, ok := m.cache["t"]; ok {
+		t.Fatal("cache entry was not removed")
+	}
+	m.WatchClose(tr)
+	if c.Subscribed("t") || c.Unsubscribes() != 1 {
+		t.Fatal("did not unsubscribe after the last user was closed")
+	}
+}
@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nathan-osman/sensorpi/jsonpath"
+)
+
+const (
+	formatPlain = "plain"
+	formatJSON  = "json"
+)
+
+type payloadParams struct {
+	Format   string `yaml:"format"`
+	Template string `yaml:"template"`
+	Name     string `yaml:"name"`
+	Unit     string `yaml:"unit"`
+}
+
+type payloadData struct {
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+	Unit      string    `json:"unit,omitempty"`
+	Name      string    `json:"name,omitempty"`
+}
+
+type payloadFormatter struct {
+	params   *payloadParams
+	template *template.Template
+}
+
+type extractParams struct {
+	JSONPath string             `yaml:"json_path"`
+	Values   map[string]float64 `yaml:"values"`
+}
+
+func newPayloadFormatter(params *payloadParams) (*payloadFormatter, error) {
+	f := &payloadFormatter{
+		params: params,
+	}
+	if params.Template != "" {
+		t, err := template.New("").Parse(params.Template)
+		if err != nil {
+			return nil, err
+		}
+		f.template = t
+		return f, nil
+	}
+	switch params.Format {
+	case "", formatPlain, formatJSON:
+	default:
+		return nil, fmt.Errorf("invalid payload format \"%s\"", params.Format)
+	}
+	return f, nil
+}
+
+// Format converts the value into a payload suitable for publishing.
+func (f *payloadFormatter) Format(v float64) ([]byte, error) {
+	d := &payloadData{
+		Value:     v,
+		Timestamp: time.Now(),
+		Unit:      f.params.Unit,
+		Name:      f.params.Name,
+	}
+	switch {
+	case f.template != nil:
+		b := &bytes.Buffer{}
+		if err := f.template.Execute(b, d); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case f.params.Format == formatJSON:
+		return json.Marshal(d)
+	default:
+		return []byte(fmt.Sprintf("%f", v)), nil
+	}
+}
+
+func (e *extractParams) convertString(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if v, ok := e.Values[s]; ok {
+		return v, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Extract converts a received payload into a value.
+func (e *extractParams) Extract(payload []byte) (float64, error) {
+	if e.JSONPath == "" {
+		return e.convertString(string(payload))
+	}
+	return jsonpath.Extract(payload, e.JSONPath, e.convertString)
+}
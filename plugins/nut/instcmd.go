@@ -0,0 +1,58 @@
+package nut
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const instCmdTimeout = 10 * time.Second
+
+// expectOK sends a command and fails unless the server responds with "OK"
+func expectOK(rw io.ReadWriter, r *bufio.Reader, cmd string) error {
+	if _, err := fmt.Fprintf(rw, "%s\n", cmd); err != nil {
+		return err
+	}
+	l, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	l = strings.TrimSpace(l)
+	if !strings.HasPrefix(l, "OK") {
+		return fmt.Errorf("server returned %s", l)
+	}
+	return nil
+}
+
+// runInstCmd logs in to the NUT server on a separate connection (the shared
+// client cannot authenticate) and runs an instant command
+func runInstCmd(addr, username, password, ups, cmd string) error {
+	conn, err := net.DialTimeout("tcp", addr, instCmdTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(instCmdTimeout)); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	cmds := []string{}
+	if username != "" {
+		cmds = append(
+			cmds,
+			fmt.Sprintf("USERNAME %s", username),
+			fmt.Sprintf("PASSWORD %s", password),
+		)
+	}
+	cmds = append(cmds, fmt.Sprintf("INSTCMD %s %s", ups, cmd))
+	for _, c := range cmds {
+		if err := expectOK(conn, r, c); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(conn, "LOGOUT\n")
+	return nil
+}
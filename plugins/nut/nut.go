@@ -1,33 +1,83 @@
 package nut
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/nathan-osman/nutclient/v3"
 	"github.com/nathan-osman/sensorpi/plugin"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	defaultAddr         = "localhost:3493"
+	defaultName         = "ups"
+	defaultPollInterval = 30 * time.Second
+
+	keyStatus = "ups.status"
+)
+
 // Nut reads data from a NUT server.
 type Nut struct {
-	client *nutclient.Client
-	name   string
+	client       *nutclient.Client
+	addr         string
+	username     string
+	password     string
+	name         string
+	pollInterval time.Duration
 }
 
 type pluginParams struct {
 	Addr         string        `yaml:"addr"`
 	Name         string        `yaml:"name"`
 	PollInterval time.Duration `yaml:"poll_interval"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
 }
 
 type inputParams struct {
-	Key string `yaml:"key"`
+	UPS    string `yaml:"ups"`
+	Key    string `yaml:"key"`
+	Status string `yaml:"status"`
+}
+
+type inputData struct {
+	ups  string
+	key  string
+	flag string
+}
+
+type outputParams struct {
+	UPS     string `yaml:"ups"`
+	Command string `yaml:"command"`
+}
+
+type outputData struct {
+	ups     string
+	command string
+}
+
+type triggerParams struct {
+	UPS    string `yaml:"ups"`
+	Status string `yaml:"status"`
+}
+
+type triggerData struct {
+	ups    string
+	flag   string
+	active *bool
 }
 
 func init() {
 	plugin.Register("nut", func(node *yaml.Node) (plugin.Plugin, error) {
-		params := &pluginParams{}
+		params := &pluginParams{
+			Addr:         defaultAddr,
+			Name:         defaultName,
+			PollInterval: defaultPollInterval,
+		}
 		if err := node.Decode(params); err != nil {
 			return nil, err
 		}
@@ -36,22 +86,64 @@ func init() {
 				Addr:              params.Addr,
 				KeepAliveInterval: 30 * time.Second,
 			}),
-			name: params.Name,
+			addr:         params.Addr,
+			username:     params.Username,
+			password:     params.Password,
+			name:         params.Name,
+			pollInterval: params.PollInterval,
 		}, nil
 	})
 }
 
+// upsName returns the UPS name for an input, output, or trigger, falling back
+// to the plugin's name for configs that predate the per-UPS parameter
+func (n *Nut) upsName(v string) string {
+	if v == "" {
+		return n.name
+	}
+	return v
+}
+
+// statusFlag returns true if the status of the UPS includes the flag
+func (n *Nut) statusFlag(ups, flag string) (bool, error) {
+	v, err := n.client.Get("VAR", ups, keyStatus)
+	if err != nil {
+		return false, err
+	}
+	return parseStatus(v)[flag], nil
+}
+
 func (n *Nut) ReadInit(node *yaml.Node) (any, error) {
 	params := &inputParams{}
 	if err := node.Decode(params); err != nil {
 		return nil, err
 	}
-	return params, nil
+	d := &inputData{
+		ups: n.upsName(params.UPS),
+		key: params.Key,
+	}
+	if params.Status != "" {
+		f, err := lookupFlag(params.Status)
+		if err != nil {
+			return nil, err
+		}
+		d.flag = f
+	} else if params.Key == "" {
+		return nil, errors.New("key or status must be specified")
+	}
+	return d, nil
 }
 
 func (n *Nut) Read(data any) (float64, error) {
-	params := data.(*inputParams)
-	v, err := n.client.Get("VAR", n.name, params.Key)
+	d := data.(*inputData)
+	if d.flag != "" {
+		active, err := n.statusFlag(d.ups, d.flag)
+		if err != nil {
+			return 0, err
+		}
+		return boolToFloat(active), nil
+	}
+	v, err := n.client.Get("VAR", d.ups, d.key)
 	if err != nil {
 		return 0, err
 	}
@@ -64,6 +156,72 @@ func (n *Nut) Read(data any) (float64, error) {
 
 func (n *Nut) ReadClose(any) {}
 
+func (n *Nut) WriteInit(node *yaml.Node) (any, error) {
+	params := &outputParams{}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	if params.Command == "" {
+		return nil, errors.New("command must be specified")
+	}
+	return &outputData{
+		ups:     n.upsName(params.UPS),
+		command: params.Command,
+	}, nil
+}
+
+// Write runs the instant command when a non-zero value is written.
+func (n *Nut) Write(data any, v float64) error {
+	d := data.(*outputData)
+	if v == 0 {
+		return nil
+	}
+	return runInstCmd(n.addr, n.username, n.password, d.ups, d.command)
+}
+
+func (n *Nut) WriteClose(any) {}
+
+func (n *Nut) WatchInit(node *yaml.Node) (any, error) {
+	params := &triggerParams{}
+	if err := node.Decode(params); err != nil {
+		return nil, err
+	}
+	f, err := lookupFlag(params.Status)
+	if err != nil {
+		return nil, err
+	}
+	return &triggerData{
+		ups:  n.upsName(params.UPS),
+		flag: f,
+	}, nil
+}
+
+// Watch polls the status of the UPS and returns 1 when the flag is set and 0
+// when it is cleared; the first status read only establishes the initial
+// state.
+func (n *Nut) Watch(data any, ctx context.Context) (float64, error) {
+	d := data.(*triggerData)
+	for {
+		active, err := n.statusFlag(d.ups, d.flag)
+		if err != nil {
+			log.Warn().Msgf("nut: %s", err.Error())
+		} else {
+			changed := d.active != nil && *d.active != active
+			d.active = &active
+			if changed {
+				return boolToFloat(active), nil
+			}
+		}
+		select {
+		case <-time.After(n.pollInterval):
+		case <-ctx.Done():
+			return 0, context.Canceled
+		}
+	}
+}
+
+func (n *Nut) WatchClose(any) {}
+
 func (n *Nut) Close() {
 	n.client.Close()
 }
@@ -1,6 +1,9 @@
 package nut
 
 import (
+	"bufio"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/nathan-osman/sensorpi/plugin"
@@ -10,4 +13,52 @@ func TestPlugin(t *testing.T) {
 	if !plugin.IsInputPlugin(&Nut{}) {
 		t.Fatal("Nut does not correctly implement InputPlugin")
 	}
+	if !plugin.IsOutputPlugin(&Nut{}) {
+		t.Fatal("Nut does not correctly implement OutputPlugin")
+	}
+	if !plugin.IsTriggerPlugin(&Nut{}) {
+		t.Fatal("Nut does not correctly implement TriggerPlugin")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	flags := parseStatus("OB LB")
+	if !flags["OB"] || !flags["LB"] || flags["OL"] {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+}
+
+func TestRunInstCmd(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cmdChan := make(chan []string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var (
+			s    = bufio.NewScanner(c)
+			cmds = []string{}
+		)
+		for s.Scan() {
+			cmds = append(cmds, s.Text())
+			if strings.HasPrefix(s.Text(), "LOGOUT") {
+				break
+			}
+			c.Write([]byte("OK\n"))
+		}
+		cmdChan <- cmds
+	}()
+	if err := runInstCmd(l.Addr().String(), "admin", "secret", "ups", "test.battery.start"); err != nil {
+		t.Fatal(err)
+	}
+	cmds := <-cmdChan
+	if len(cmds) != 4 || cmds[2] != "INSTCMD ups test.battery.start" {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
 }
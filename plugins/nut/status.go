@@ -0,0 +1,44 @@
+package nut
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status flags reported in ups.status, keyed by the name used in the config
+var statusFlags = map[string]string{
+	"online":          "OL",
+	"on_battery":      "OB",
+	"low_battery":     "LB",
+	"charging":        "CHRG",
+	"discharging":     "DISCHRG",
+	"replace_battery": "RB",
+	"overload":        "OVER",
+	"bypass":          "BYPASS",
+	"calibrating":     "CAL",
+	"off":             "OFF",
+}
+
+func lookupFlag(name string) (string, error) {
+	f, ok := statusFlags[name]
+	if !ok {
+		return "", fmt.Errorf("unknown status \"%s\"", name)
+	}
+	return f, nil
+}
+
+// parseStatus splits a status string such as "OB LB" into its flags
+func parseStatus(v string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, f := range strings.Fields(v) {
+		flags[f] = true
+	}
+	return flags
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
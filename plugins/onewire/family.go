@@ -0,0 +1,257 @@
+package onewire
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	familyDS18S20  = "10"
+	familyDS1822   = "22"
+	familyDS18B20  = "28"
+	familyDS2438   = "26"
+	familyDS2413   = "3a"
+	familyDS1825   = "3b"
+	familyDS28EA00 = "42"
+
+	quantityTemperature   = "temperature"
+	quantityHumidity      = "humidity"
+	quantityVoltage       = "voltage"
+	quantitySupplyVoltage = "supply_voltage"
+	quantityPioA          = "pio_a"
+	quantityPioB          = "pio_b"
+
+	sentinelPowerOn   = 85000
+	sentinelReadError = -127000
+)
+
+// reader reads a single quantity from the sysfs directory of a device
+type reader func(path string) (float64, error)
+
+// family returns the family code from a device ID
+func family(id string) string {
+	f, _, _ := strings.Cut(id, "-")
+	return strings.ToLower(f)
+}
+
+// isThermometer returns true for the families handled by the w1_therm driver
+func isThermometer(id string) bool {
+	switch family(id) {
+	case familyDS18S20, familyDS1822, familyDS18B20, familyDS1825, familyDS28EA00:
+		return true
+	default:
+		return false
+	}
+}
+
+// readerFor returns a reader for the quantity, provided the device family
+// supports it
+func readerFor(id, quantity string, useW1Slave bool) (reader, error) {
+	switch family(id) {
+	case familyDS18S20, familyDS1822, familyDS18B20, familyDS1825, familyDS28EA00:
+		if quantity == quantityTemperature {
+			if useW1Slave {
+				return readW1Slave, nil
+			}
+			return readTemperature, nil
+		}
+	case familyDS2438:
+		switch quantity {
+		case quantityTemperature:
+			return readDS2438Temperature, nil
+		case quantityHumidity:
+			return readDS2438Humidity, nil
+		case quantityVoltage:
+			return readDS2438Voltage("vad"), nil
+		case quantitySupplyVoltage:
+			return readDS2438Voltage("vdd"), nil
+		}
+	case familyDS2413:
+		switch quantity {
+		case quantityPioA:
+			return readDS2413(0), nil
+		case quantityPioB:
+			return readDS2413(1), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported device family for %s", id)
+	}
+	return nil, fmt.Errorf("%s does not support quantity \"%s\"", id, quantity)
+}
+
+func readInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// checkTemperature rejects the values returned by a thermometer that has just
+// been powered on (85 °C) or that could not be read (-127 °C)
+func checkTemperature(millidegrees int64) (float64, error) {
+	switch millidegrees {
+	case sentinelPowerOn, sentinelReadError:
+		return 0, fmt.Errorf("invalid temperature %.3f", float64(millidegrees)/1000)
+	}
+	return float64(millidegrees) / 1000, nil
+}
+
+// readTemperature reads the temperature in millidegrees reported by the
+// thermometer families
+func readTemperature(path string) (float64, error) {
+	v, err := readInt(filepath.Join(path, "temperature"))
+	if err != nil {
+		return 0, err
+	}
+	return checkTemperature(v)
+}
+
+// readW1Slave reads the scratchpad dump in w1_slave, which includes the
+// result of the CRC check, and extracts the temperature from it
+func readW1Slave(path string) (float64, error) {
+	b, err := os.ReadFile(filepath.Join(path, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	return parseW1Slave(string(b))
+}
+
+func parseW1Slave(v string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(v), "\n")
+	if len(lines) != 2 {
+		return 0, errors.New("unexpected w1_slave format")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, errors.New("CRC check failed")
+	}
+	_, t, ok := strings.Cut(lines[1], "t=")
+	if !ok {
+		return 0, errors.New("temperature missing from w1_slave")
+	}
+	m, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return checkTemperature(m)
+}
+
+// setResolution sets the resolution of a thermometer in bits
+func setResolution(path string, bits int) error {
+	if bits < 9 || bits > 12 {
+		return errors.New("resolution must be between 9 and 12 bits")
+	}
+	return os.WriteFile(
+		filepath.Join(path, "resolution"),
+		[]byte(strconv.Itoa(bits)),
+		0644,
+	)
+}
+
+// readDS2438Temperature reads the raw temperature register, which is in units
+// of 1/256 °C
+func readDS2438Temperature(path string) (float64, error) {
+	v, err := readInt(filepath.Join(path, "temperature"))
+	if err != nil {
+		return 0, err
+	}
+	return float64(v) / 256, nil
+}
+
+// readDS2438Voltage returns a reader for one of the voltage attributes, which
+// are in units of 10 mV
+func readDS2438Voltage(name string) reader {
+	return func(path string) (float64, error) {
+		v, err := readInt(filepath.Join(path, name))
+		if err != nil {
+			return 0, err
+		}
+		return float64(v) / 100, nil
+	}
+}
+
+// readDS2438Humidity calculates the relative humidity from an HIH-4000 style
+// sensor connected to VAD, compensated for temperature
+func readDS2438Humidity(path string) (float64, error) {
+	vad, err := readDS2438Voltage("vad")(path)
+	if err != nil {
+		return 0, err
+	}
+	vdd, err := readDS2438Voltage("vdd")(path)
+	if err != nil {
+		return 0, err
+	}
+	if vdd == 0 {
+		return 0, errors.New("supply voltage is zero")
+	}
+	t, err := readDS2438Temperature(path)
+	if err != nil {
+		return 0, err
+	}
+	return ds2438Humidity(vad, vdd, t), nil
+}
+
+func ds2438Humidity(vad, vdd, t float64) float64 {
+	rh := (vad/vdd - 0.16) / 0.0062
+	rh = rh / (1.0546 - 0.00216*t)
+	return math.Max(0, math.Min(100, rh))
+}
+
+func parseChannel(v string) (int, error) {
+	switch strings.ToLower(v) {
+	case "a", "":
+		return 0, nil
+	case "b":
+		return 1, nil
+	default:
+		return 0, errors.New("channel must be \"a\" or \"b\"")
+	}
+}
+
+// readDS2413State reads the state byte; for each channel the lower bit is
+// the pin level and the upper bit is the output latch
+func readDS2413State(path string) (byte, error) {
+	b, err := os.ReadFile(filepath.Join(path, "state"))
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 1 {
+		return 0, errors.New("invalid DS2413 state")
+	}
+	return b[0], nil
+}
+
+// readDS2413 returns a reader for the input level of a channel
+func readDS2413(channel int) reader {
+	return func(path string) (float64, error) {
+		s, err := readDS2413State(path)
+		if err != nil {
+			return 0, err
+		}
+		return float64(s >> (channel * 2) & 1), nil
+	}
+}
+
+// writeDS2413 switches a channel on (the output transistor conducts) or off,
+// leaving the other channel's latch unchanged
+func writeDS2413(path string, channel int, on bool) error {
+	s, err := readDS2413State(path)
+	if err != nil {
+		return err
+	}
+	var (
+		out = (s>>1)&1 | (s>>2)&2
+		bit = byte(1 << channel)
+	)
+	if on {
+		out &^= bit
+	} else {
+		out |= bit
+	}
+	return os.WriteFile(filepath.Join(path, "output"), []byte{out}, 0644)
+}
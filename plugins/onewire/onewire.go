@@ -1,55 +1,200 @@
 package onewire
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/nathan-osman/sensorpi/plugin"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
-// OneWire reads data from a OneWire sensor.
-type OneWire struct{}
+const defaultBasePath = "/sys/bus/w1/devices"
 
-type inputParams struct {
+// OneWire reads data from 1-Wire sensors and drives 1-Wire switches using the
+// kernel's sysfs interface.
+type OneWire struct {
+	basePath string
+	aliases  map[string]string
+}
+
+type pluginParams struct {
+	BasePath string            `yaml:"base_path"`
+	Aliases  map[string]string `yaml:"aliases"`
+}
+
+// deviceParams identifies a device either by ID (or alias) or by its position
+// among the discovered devices of a family
+type deviceParams struct {
 	Device string `yaml:"device"`
+	Family string `yaml:"family"`
+	Index  int    `yaml:"index"`
+}
+
+type inputParams struct {
+	deviceParams `yaml:",inline"`
+	Quantity     string `yaml:"quantity"`
+	W1Slave      bool   `yaml:"w1_slave"`
+	Resolution   int    `yaml:"resolution"`
+}
+
+type inputData struct {
+	path   string
+	reader reader
+}
+
+type outputParams struct {
+	deviceParams `yaml:",inline"`
+	Channel      string `yaml:"channel"`
+}
+
+type outputData struct {
+	path    string
+	channel int
 }
 
 func init() {
 	plugin.Register("onewire", func(node *yaml.Node) (plugin.Plugin, error) {
-		return &OneWire{}, nil
+		params := &pluginParams{
+			BasePath: defaultBasePath,
+		}
+		// The plugin block is optional, in which case node is nil
+		if node != nil {
+			if err := node.Decode(params); err != nil {
+				return nil, err
+			}
+		}
+		o := &OneWire{
+			basePath: params.BasePath,
+			aliases:  params.Aliases,
+		}
+		if devices, err := o.discover(""); err != nil {
+			log.Warn().Msgf("onewire: %s", err.Error())
+		} else {
+			log.Info().Msgf("onewire: found devices: %s", strings.Join(devices, ", "))
+		}
+		return o, nil
 	})
 }
 
+// discover returns the sorted IDs of the devices present, optionally limited
+// to a single family
+func (o *OneWire) discover(family string) ([]string, error) {
+	entries, err := os.ReadDir(o.basePath)
+	if err != nil {
+		return nil, err
+	}
+	devices := []string{}
+	for _, e := range entries {
+		f, _, ok := strings.Cut(e.Name(), "-")
+		if !ok || (family != "" && f != family) {
+			// Skip bus masters and devices from other families
+			continue
+		}
+		devices = append(devices, e.Name())
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
+
+// resolve returns the sysfs directory of the device described by the
+// parameters
+func (o *OneWire) resolve(p *deviceParams) (string, error) {
+	id := p.Device
+	if a, ok := o.aliases[id]; ok {
+		id = a
+	}
+	if id == "" {
+		if p.Family == "" {
+			return "", errors.New("device or family must be specified")
+		}
+		devices, err := o.discover(p.Family)
+		if err != nil {
+			return "", err
+		}
+		if p.Index < 0 || p.Index >= len(devices) {
+			return "", fmt.Errorf("device %d of family %s not found", p.Index, p.Family)
+		}
+		id = devices[p.Index]
+	}
+	return filepath.Join(o.basePath, id), nil
+}
+
 func (o *OneWire) ReadInit(node *yaml.Node) (any, error) {
-	params := &inputParams{}
+	params := &inputParams{
+		deviceParams: deviceParams{
+			Family: familyDS18B20,
+		},
+		Quantity: quantityTemperature,
+	}
 	if err := node.Decode(params); err != nil {
-		return 0, err
+		return nil, err
+	}
+	p, err := o.resolve(&params.deviceParams)
+	if err != nil {
+		return nil, err
+	}
+	id := filepath.Base(p)
+	r, err := readerFor(id, params.Quantity, params.W1Slave)
+	if err != nil {
+		return nil, err
 	}
-	return params, nil
+	if params.Resolution != 0 {
+		if !isThermometer(id) {
+			return nil, errors.New("resolution can only be set for thermometers")
+		}
+		if err := setResolution(p, params.Resolution); err != nil {
+			return nil, err
+		}
+	}
+	return &inputData{
+		path:   p,
+		reader: r,
+	}, nil
 }
 
 func (o *OneWire) Read(data any) (float64, error) {
-	params := data.(*inputParams)
-	f, err := os.Open(fmt.Sprintf("/sys/bus/w1/devices/%s/temperature", params.Device))
-	if err != nil {
-		return 0, err
+	d := data.(*inputData)
+	return d.reader(d.path)
+}
+
+func (o *OneWire) ReadClose(any) {}
+
+func (o *OneWire) WriteInit(node *yaml.Node) (any, error) {
+	params := &outputParams{
+		deviceParams: deviceParams{
+			Family: familyDS2413,
+		},
+	}
+	if err := node.Decode(params); err != nil {
+		return nil, err
 	}
-	defer f.Close()
-	b, err := io.ReadAll(f)
+	p, err := o.resolve(&params.deviceParams)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if family(filepath.Base(p)) != familyDS2413 {
+		return nil, errors.New("only DS2413 devices can be used as outputs")
 	}
-	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	c, err := parseChannel(params.Channel)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return float64(v) / 1000, nil
+	return &outputData{
+		path:    p,
+		channel: c,
+	}, nil
 }
 
-func (o *OneWire) ReadClose(any) {}
+func (o *OneWire) Write(data any, v float64) error {
+	d := data.(*outputData)
+	return writeDS2413(d.path, d.channel, v != 0)
+}
+
+func (o *OneWire) WriteClose(any) {}
 
 func (o *OneWire) Close() {}
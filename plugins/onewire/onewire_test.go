@@ -1,13 +1,144 @@
 package onewire
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/nathan-osman/sensorpi/plugin"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPlugin(t *testing.T) {
 	if !plugin.IsInputPlugin(&OneWire{}) {
 		t.Fatal("OneWire does not correctly implement InputPlugin")
 	}
+	if !plugin.IsOutputPlugin(&OneWire{}) {
+		t.Fatal("OneWire does not correctly implement OutputPlugin")
+	}
+}
+
+func TestCreateWithoutParams(t *testing.T) {
+	p, err := plugin.Create("onewire", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := p.(*OneWire); o.basePath != defaultBasePath {
+		t.Fatalf("%s != %s", o.basePath, defaultBasePath)
+	}
+}
+
+// fakeSysfs creates a directory tree resembling /sys/bus/w1/devices
+func fakeSysfs(t *testing.T, files map[string]string) string {
+	d := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(d, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func readValue(t *testing.T, o *OneWire, params string) float64 {
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(params), n); err != nil {
+		t.Fatal(err)
+	}
+	d, err := o.ReadInit(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := o.Read(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRead(t *testing.T) {
+	o := &OneWire{
+		basePath: fakeSysfs(t, map[string]string{
+			"w1_bus_master1/uevent":       "",
+			"28-0516a43c9fff/temperature": "21500\n",
+			"28-0316a43c9aaa/temperature": "-1250\n",
+			"26-000000aaaaaa/temperature": "5760\n",
+			"26-000000aaaaaa/vad":         "200\n",
+			"26-000000aaaaaa/vdd":         "500\n",
+			"3a-000000bbbbbb/state":       "\x45",
+		}),
+		aliases: map[string]string{
+			"garage": "28-0516a43c9fff",
+		},
+	}
+	for _, v := range []struct {
+		params string
+		value  float64
+	}{
+		{"device: 28-0516a43c9fff", 21.5},
+		{"device: garage", 21.5},
+		{"index: 0", -1.25},
+		{"family: \"26\"\nquantity: temperature", 22.5},
+		{"family: \"26\"\nquantity: voltage", 2},
+		{"family: \"26\"\nquantity: supply_voltage", 5},
+		{"family: 3a\nquantity: pio_a", 1},
+		{"family: 3a\nquantity: pio_b", 1},
+	} {
+		if r := readValue(t, o, v.params); r != v.value {
+			t.Fatalf("%s: %f != %f", v.params, r, v.value)
+		}
+	}
+	devices, err := o.discover("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 4 {
+		t.Fatalf("%d devices found, expected 4", len(devices))
+	}
+}
+
+func TestWriteDS2413(t *testing.T) {
+	p := fakeSysfs(t, map[string]string{
+		"3a-000000bbbbbb/state": "\x0f",
+	})
+	d := filepath.Join(p, "3a-000000bbbbbb")
+	if err := writeDS2413(d, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(d, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 || b[0] != 0x01 {
+		t.Fatalf("unexpected output %v", b)
+	}
+}
+
+func TestParseW1Slave(t *testing.T) {
+	for _, v := range []struct {
+		content string
+		value   float64
+		valid   bool
+	}{
+		{"72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 23.125, true},
+		{"72 01 4b 46 7f ff 0e 10 57 : crc=32 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 0, false},
+		{"50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n", 0, false},
+		{"ff ff ff ff ff ff ff ff ff : crc=c9 YES\nff ff ff ff ff ff ff ff ff t=-127000\n", 0, false},
+		{"", 0, false},
+	} {
+		r, err := parseW1Slave(v.content)
+		if v.valid {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != v.value {
+				t.Fatalf("%f != %f", r, v.value)
+			}
+		} else if err == nil {
+			t.Fatalf("%q was accepted", v.content)
+		}
+	}
 }
@@ -0,0 +1,123 @@
+// Package queue buffers values between a producer that must not block and a
+// trigger that consumes them.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Policies for handling a value received while the queue is full. Coalesce
+// applies whether or not the queue is full, so that only the latest value is
+// ever pending.
+const (
+	OverflowDropOldest = "drop_oldest"
+	OverflowDropNewest = "drop_newest"
+	OverflowCoalesce   = "coalesce"
+)
+
+// Params configures a queue. It is intended to be inlined in trigger
+// parameters.
+type Params struct {
+	QueueSize int    `yaml:"queue_size"`
+	Overflow  string `yaml:"overflow"`
+}
+
+// Queue buffers values until they are consumed. Producers such as MQTT
+// message handlers must never block, so Push always returns immediately.
+type Queue struct {
+	mutex      sync.Mutex
+	values     []float64
+	size       int
+	overflow   string
+	closed     bool
+	notifyChan chan any
+}
+
+// New creates a new queue using the provided parameters.
+func New(params *Params) (*Queue, error) {
+	var (
+		size     = params.QueueSize
+		overflow = params.Overflow
+	)
+	if size == 0 {
+		size = 10
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid queue size %d", size)
+	}
+	if overflow == "" {
+		overflow = OverflowDropOldest
+	}
+	switch overflow {
+	case OverflowDropOldest, OverflowDropNewest, OverflowCoalesce:
+	default:
+		return nil, fmt.Errorf("invalid overflow policy \"%s\"", overflow)
+	}
+	return &Queue{
+		size:       size,
+		overflow:   overflow,
+		notifyChan: make(chan any, 1),
+	}, nil
+}
+
+// Push adds a value to the queue, applying the overflow policy if the queue
+// is full. Values pushed after Close are discarded.
+func (q *Queue) Push(v float64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	switch {
+	case q.overflow == OverflowCoalesce:
+		q.values = q.values[:0]
+	case len(q.values) >= q.size:
+		if q.overflow == OverflowDropNewest {
+			return
+		}
+		q.values = q.values[1:]
+	}
+	q.values = append(q.values, v)
+	select {
+	case q.notifyChan <- nil:
+	default:
+	}
+}
+
+// Pop waits for a value to become available. If the context is cancelled or
+// the queue is closed, context.Canceled is returned.
+func (q *Queue) Pop(ctx context.Context) (float64, error) {
+	for {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return 0, context.Canceled
+		}
+		if len(q.values) > 0 {
+			v := q.values[0]
+			q.values = q.values[1:]
+			q.mutex.Unlock()
+			return v, nil
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.notifyChan:
+		case <-ctx.Done():
+			return 0, context.Canceled
+		}
+	}
+}
+
+// Close discards any pending values and wakes up a waiting Pop.
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.values = nil
+	select {
+	case q.notifyChan <- nil:
+	default:
+	}
+}
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	for _, v := range []struct {
+		size     int
+		overflow string
+		values   []float64
+	}{
+		{2, OverflowDropOldest, []float64{2, 3}},
+		{2, OverflowDropNewest, []float64{1, 2}},
+		{2, OverflowCoalesce, []float64{3}},
+		{10, OverflowDropOldest, []float64{1, 2, 3}},
+		{10, OverflowCoalesce, []float64{3}},
+	} {
+		q, err := New(&Params{QueueSize: v.size, Overflow: v.overflow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []float64{1, 2, 3} {
+			q.Push(f)
+		}
+		for _, f := range v.values {
+			r, err := q.Pop(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != f {
+				t.Fatalf("%s: %f != %f", v.overflow, r, f)
+			}
+		}
+		if len(q.values) != 0 {
+			t.Fatalf("%s: unexpected values %v", v.overflow, q.values)
+		}
+		q.Close()
+		if _, err := q.Pop(context.Background()); err != context.Canceled {
+			t.Fatalf("%s: expected context.Canceled", v.overflow)
+		}
+	}
+}